@@ -1,4 +1,7 @@
 package main
 
-var db, e = createMux()
-var mappings = createMappings()
+import "github.com/asdine/storm"
+
+var db *storm.DB
+var e = createMux()
+var mappings map[string]*storm.DB
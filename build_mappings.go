@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// selectedColumns lists the ID types found in the columns of UniProt's
+// idmapping_selected.tab file.
+var selectedColumns = []string{
+	"UniProtKB-AC",
+	"UniProtKB-ID",
+	"GeneID",
+	"RefSeq",
+	"GI",
+	"PDB",
+	"GO",
+	"UniRef100",
+	"UniRef90",
+	"UniRef50",
+	"UniParc",
+	"PIR",
+	"NCBI_TaxID",
+	"MIM",
+	"UniGene",
+	"PubMed",
+	"EMBL",
+	"EMBL-CDS",
+	"Ensembl",
+	"Ensembl_TRS",
+	"Ensembl_PRO",
+	"Additional PubMed",
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+type mappingStore struct {
+	Name    string
+	Path    string
+	DB      *storm.DB
+	Pending map[string][]string
+	Reverse map[string][]string
+}
+
+func openMappingStore(dir, name string, incremental bool) (*mappingStore, error) {
+	path := filepath.Join(dir, name+".db")
+
+	if !incremental {
+		path += ".build"
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	db, err := storm.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappingStore{
+		Name:    name,
+		Path:    path,
+		DB:      db,
+		Pending: make(map[string][]string),
+		Reverse: make(map[string][]string),
+	}, nil
+}
+
+func (m *mappingStore) Release() string {
+	var release string
+	m.DB.Get("Metadata", "release", &release)
+	return release
+}
+
+func mergeRelations(item *Mapping, id, stem, release string, relations []string) {
+	// Relations left over from an older release are replaced, not merged.
+	if item.Release != release {
+		*item = Mapping{ID: id}
+	}
+
+	item.Stem = stem
+	item.Release = release
+
+	for _, relation := range relations {
+		if !containsString(item.Relations, relation) {
+			item.Relations = append(item.Relations, relation)
+		}
+	}
+}
+
+func (m *mappingStore) Flush(release string) error {
+	if len(m.Pending) == 0 && len(m.Reverse) == 0 {
+		return nil
+	}
+
+	tx, err := m.DB.Begin(true)
+
+	if err != nil {
+		return err
+	}
+
+	for id, relations := range m.Pending {
+		var item Mapping
+
+		if err := tx.One("ID", id, &item); err != nil && err != storm.ErrNotFound {
+			tx.Rollback()
+			return err
+		}
+
+		stem, _ := splitVersion(m.Name, id)
+
+		mergeRelations(&item, id, stem, release, relations)
+
+		if err := tx.Save(&item); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for accession, ids := range m.Reverse {
+		var item ReverseMapping
+
+		if err := tx.One("ID", accession, &item); err != nil && err != storm.ErrNotFound {
+			tx.Rollback()
+			return err
+		}
+
+		mapping := Mapping(item)
+
+		mergeRelations(&mapping, accession, accession, release, ids)
+
+		item = ReverseMapping(mapping)
+
+		if err := tx.Save(&item); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	m.Pending = make(map[string][]string)
+	m.Reverse = make(map[string][]string)
+
+	return tx.Commit()
+}
+
+func (m *mappingStore) Finish(dir, release string) error {
+	if err := m.Flush(release); err != nil {
+		return err
+	}
+
+	// Drop IDs that no longer appear in the current release.
+	if err := m.DB.Select(q.Not(q.Eq("Release", release))).Delete(new(Mapping)); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	if err := m.DB.Select(q.Not(q.Eq("Release", release))).Delete(new(ReverseMapping)); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	if err := m.DB.Set("Metadata", "release", release); err != nil {
+		return err
+	}
+
+	if err := m.DB.Close(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, m.Name+".db")
+
+	if m.Path != path {
+		return os.Rename(m.Path, path)
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func openMappingSource(source string) (io.ReadCloser, int64, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		res, err := upstream.Get(context.Background(), source)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if res.StatusCode < 200 || 299 < res.StatusCode {
+			res.Body.Close()
+			return nil, 0, fmt.Errorf("%s: %s", source, res.Status)
+		}
+
+		return res.Body, res.ContentLength, nil
+	}
+
+	file, err := os.Open(source)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+func buildMappings(args []string) error {
+	mappingPath := getEnv("MAPPING_PATH", "mappings")
+
+	flags := flag.NewFlagSet("build-mappings", flag.ExitOnError)
+
+	source := flags.String("source", "idmapping.dat.gz", "path or URL of idmapping.dat or idmapping_selected.tab (optionally gzipped)")
+	dir := flags.String("out", mappingPath, "directory to write the mapping stores to")
+	release := flags.String("release", time.Now().Format("2006-01-02"), "UniProt release the source belongs to")
+	types := flags.String("types", "", "comma separated list of ID types to build (default: all)")
+	incremental := flags.Bool("incremental", false, "update existing stores in place and skip stores already at the release")
+	batch := flags.Int("batch", 100000, "number of IDs to buffer before writing to the stores")
+
+	flags.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	var selected map[string]bool
+
+	if len(*types) > 0 {
+		selected = make(map[string]bool)
+
+		for _, name := range strings.Split(*types, ",") {
+			selected[name] = true
+		}
+	}
+
+	rc, size, err := openMappingSource(*source)
+
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	counter := &countingReader{reader: rc}
+
+	var reader io.Reader = counter
+
+	if strings.HasSuffix(*source, ".gz") {
+		gz, err := gzip.NewReader(counter)
+
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	stores := make(map[string]*mappingStore)
+	skipped := make(map[string]bool)
+
+	defer func() {
+		for _, store := range stores {
+			store.DB.Close()
+		}
+	}()
+
+	pending := 0
+
+	add := func(name, id, accession string) error {
+		if len(id) == 0 || name == "UniProtKB-AC" || skipped[name] {
+			return nil
+		}
+
+		if selected != nil && !selected[name] {
+			return nil
+		}
+
+		store, ok := stores[name]
+
+		if !ok {
+			store, err = openMappingStore(*dir, name, *incremental)
+
+			if err != nil {
+				return err
+			}
+
+			if *incremental && store.Release() == *release {
+				log.Printf("Mapping %s is already at release %s", name, *release)
+				store.DB.Close()
+				skipped[name] = true
+				return nil
+			}
+
+			log.Printf("Building mapping %s", name)
+
+			stores[name] = store
+		}
+
+		if _, ok := store.Pending[id]; !ok {
+			pending++
+		}
+
+		store.Pending[id] = append(store.Pending[id], accession)
+		store.Reverse[accession] = append(store.Reverse[accession], id)
+
+		if pending < *batch {
+			return nil
+		}
+
+		for _, store := range stores {
+			if err := store.Flush(*release); err != nil {
+				return err
+			}
+		}
+
+		pending = 0
+
+		return nil
+	}
+
+	selectedFormat := strings.Contains(filepath.Base(*source), "selected")
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	lines := 0
+	start := time.Now()
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		accession, _ := splitVersion("UniProtKB-AC", fields[0])
+
+		if selectedFormat {
+			for i := 1; i < len(fields) && i < len(selectedColumns); i++ {
+				for _, id := range strings.Split(fields[i], "; ") {
+					if err := add(selectedColumns[i], id, accession); err != nil {
+						return err
+					}
+				}
+			}
+		} else if len(fields) == 3 {
+			if err := add(fields[1], fields[2], accession); err != nil {
+				return err
+			}
+		}
+
+		lines++
+
+		if lines%1000000 == 0 {
+			if size > 0 {
+				log.Printf("Read %d lines (%.1f%%) in %s", lines, float64(counter.count)*100/float64(size), time.Since(start))
+			} else {
+				log.Printf("Read %d lines in %s", lines, time.Since(start))
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Read %d lines in %s, writing %d mappings", lines, time.Since(start), len(stores))
+
+	for name, store := range stores {
+		if err := store.Finish(*dir, *release); err != nil {
+			return err
+		}
+
+		delete(stores, name)
+
+		log.Printf("Finished mapping %s", name)
+	}
+
+	return nil
+}
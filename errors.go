@@ -5,8 +5,17 @@ import "errors"
 var (
 	errConversionFailed  = errors.New("query could not be converted to uniprot")
 	errTimestampInvalid  = errors.New("cache timestampe was too old")
+	errCacheOutdated     = errors.New("cache entry was stored by an older version")
 	errDBHostNotDefined  = errors.New("host for given database was not found")
 	errHTTPGetClientErr  = errors.New("http get failed with client error")
 	errHTTPGetServerErr  = errors.New("http get failed with server error")
 	errHTTPGetUnknownErr = errors.New("http get failed for some unknown error")
+	errNotRetrieved      = errors.New("entry could not be retrieved")
+	errRequestTimeout    = errors.New("request timed out")
+	errOffline           = errors.New("upstream requests are disabled in offline mode")
+	errFixtureNotFound   = errors.New("no recorded fixture for request")
+	errIDTooLong         = errors.New("id is too long to fit in a request url")
+	errTooManyRedirects  = errors.New("stopped after 10 redirects")
+	errJobTimeout        = errors.New("job did not finish in time")
+	errUnexpectedStatus  = errors.New("job has an unknown status")
 )
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fixture holds the recorded metadata of an upstream response. The body is
+// kept in a separate file next to it.
+type fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+}
+
+// fixtureTransport records upstream responses to a directory or replays them
+// from it, so that every code path can run without live services. Repeated
+// identical requests, such as job status polls, are recorded and replayed in
+// order.
+type fixtureTransport struct {
+	Dir       string
+	Record    bool
+	Transport http.RoundTripper
+
+	mutex sync.Mutex
+	seen  map[string]int
+}
+
+func newFixtureTransport(dir string, record bool) *fixtureTransport {
+	return &fixtureTransport{
+		Dir:       dir,
+		Record:    record,
+		Transport: http.DefaultTransport,
+		seen:      make(map[string]int),
+	}
+}
+
+// path returns the fixture path for a request, without extension. Requests
+// are identified by their method, URL and body, followed by the number of
+// identical requests made before.
+func (f *fixtureTransport) path(req *http.Request, body []byte) string {
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.String())
+	hash.Write(body)
+
+	name := filepath.Join(f.Dir, req.URL.Host, hex.EncodeToString(hash.Sum(nil))[:16])
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n := f.seen[name]
+	f.seen[name]++
+
+	// Replay the last recorded response once the recorded ones run out.
+	if !f.Record {
+		for n > 0 {
+			if _, err := os.Stat(fmt.Sprintf("%s-%d.json", name, n)); err == nil {
+				break
+			}
+
+			n--
+		}
+	}
+
+	return fmt.Sprintf("%s-%d", name, n)
+}
+
+func (f *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	path := f.path(req, body)
+
+	if f.Record {
+		return f.record(req, path)
+	}
+
+	return f.replay(req, path)
+}
+
+func (f *fixtureTransport) record(req *http.Request, path string) (*http.Response, error) {
+	res, err := f.Transport.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := json.MarshalIndent(fixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: res.StatusCode,
+		Header: res.Header,
+	}, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path+".json", meta, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path+".body", body, 0644); err != nil {
+		return nil, err
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return res, nil
+}
+
+func (f *fixtureTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	meta, err := ioutil.ReadFile(path + ".json")
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s %s", errFixtureNotFound, req.Method, req.URL)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var item fixture
+
+	if err := json.Unmarshal(meta, &item); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(path + ".body")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", item.Status, http.StatusText(item.Status)),
+		StatusCode:    item.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        item.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
 )
 
@@ -33,14 +33,10 @@ func (g geneOntology) ToGlinks() []glinksLink {
 	return []glinksLink{item}
 }
 
-func init() {
-	updateGeneOntology()
-}
-
 func updateGeneOntology() {
 	log.Println("Updating Gene Ontology")
 
-	res, err := http.Get("http://purl.obolibrary.org/obo/go.obo")
+	res, err := upstream.Get(context.Background(), geneOntologyURL)
 
 	if err != nil {
 		log.Fatal(err)
@@ -49,10 +45,20 @@ func updateGeneOntology() {
 
 	defer res.Body.Close()
 
+	if err := checkStatus(res); err != nil {
+		log.Fatal(err)
+		return
+	}
+
 	scanner := bufio.NewScanner(res.Body)
 
+	var version string
+
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.HasPrefix(line, "data-version: ") {
+			version = strings.TrimPrefix(line, "data-version: ")
+		}
 		if line == "[Term]" {
 			var item geneOntology
 
@@ -84,6 +90,10 @@ func updateGeneOntology() {
 			}
 		}
 	}
+
+	if err := saveSourceRelease("GO", version); err != nil {
+		log.Printf("Failed to save Gene Ontology release: %s", err)
+	}
 }
 
 func getGeneOntology(query string) (item geneOntology, err error) {
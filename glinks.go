@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"html"
 	"log"
 	"sort"
 	"strings"
@@ -15,11 +17,14 @@ const (
 )
 
 type glinksLink struct {
-	DB   string `json:"db"`
-	ID   string `json:"id"`
-	Link string `json:"link,omitempty"`
-	Text string `json:"text,omitempty"`
-	Flag int    `json:",omitempty"`
+	DB       string   `json:"db"`
+	ID       string   `json:"id"`
+	Link     string   `json:"link,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Start    int      `json:"start,omitempty"`
+	End      int      `json:"end,omitempty"`
+	Evidence []string `json:"evidence,omitempty"`
+	Flag     int      `json:",omitempty"`
 }
 
 func createGlinksLink(db, id, link, text string) (item glinksLink) {
@@ -60,14 +65,14 @@ func (g glinksLink) HTML() []string {
 func formatText(db, id, text string) string {
 	return fmt.Sprintf(
 		"<tr><td># %s</td><td>%s</td><td>%s</td></tr>",
-		db, id, text,
+		html.EscapeString(db), html.EscapeString(id), html.EscapeString(text),
 	)
 }
 
 func formatLink(db, id, link string) string {
 	return fmt.Sprintf(
 		"<tr><td>%s</td><td>%s</td><td><a href=\"%s\">%s</a></td></tr>",
-		db, id, link, link,
+		html.EscapeString(db), html.EscapeString(id), html.EscapeString(link), html.EscapeString(link),
 	)
 }
 
@@ -87,14 +92,21 @@ func (g glinksLink) TSV() []string {
 
 type glinks struct {
 	ID        string
+	Label     string
 	Links     []glinksLink
 	UpdatedAt time.Time
 }
 
 type glinksOut struct {
 	Uniprot string       `json:"uniprot"`
+	Label   string       `json:"label,omitempty"`
 	Results []glinksLink `json:"results"`
-	
+}
+
+type glinksResponse struct {
+	Results []glinksOut `json:"results"`
+	Partial bool        `json:"partial"`
+	glinksReport
 }
 
 func (g glinks) HTML() string {
@@ -106,18 +118,24 @@ func (g glinks) HTML() string {
 
 	sort.Strings(body)
 
-	html := fmt.Sprintf(
+	var caption string
+
+	if len(g.Label) > 0 {
+		caption = fmt.Sprintf("<caption style=\"text-align: left;\">%s (%s)</caption>", html.EscapeString(g.Label), html.EscapeString(g.ID))
+	}
+
+	return fmt.Sprintf(
 		"<table style=\"font-size: 0.8rem;\">"+
+			"%s"+
 			"<thead style=\"text-align: left;\">"+
 			"<tr><th>Database</th><th>ID</th><th>Description</th></tr></thead>"+
 			"<tbody>"+
 			"%s"+
 			"</tbody>"+
 			"</table>",
+		caption,
 		strings.Join(body, "\n"),
 	)
-
-	return html
 }
 
 func (g glinks) TSV() string {
@@ -136,14 +154,133 @@ type glinksCompatible interface {
 	ToGlinks() []glinksLink
 }
 
-func getGlinks(ids []string) (ret []glinks, err error) {
-	list, err := getUniprot(ids)
+// glinksIssue describes a problem encountered while gathering G-Links
+// records, optionally tied to a single ID.
+type glinksIssue struct {
+	Source  string `json:"source"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// String describes the issue in a single line, e.g. "UniProt P99999 no entry
+// found".
+func (i glinksIssue) String() string {
+	return strings.Join(filterEmpty([]string{i.Source, i.ID, i.Message}), " ")
+}
+
+// glinksReport collects the issues of a request. Errors are upstream
+// failures, warnings are IDs for which the source had no data.
+type glinksReport struct {
+	Errors   []glinksIssue `json:"errors,omitempty"`
+	Warnings []glinksIssue `json:"warnings,omitempty"`
+}
+
+func (r *glinksReport) Error(source, id string, err error) {
+	r.Errors = append(r.Errors, glinksIssue{Source: source, ID: id, Message: err.Error()})
+}
+
+func (r *glinksReport) Warn(source, id, message string) {
+	r.Warnings = append(r.Warnings, glinksIssue{Source: source, ID: id, Message: message})
+}
+
+func (r glinksReport) HTML() string {
+	var body []string
+
+	for _, issue := range r.Errors {
+		body = append(body, fmt.Sprintf("<li>Error: %s %s %s</li>", html.EscapeString(issue.Source), html.EscapeString(issue.ID), html.EscapeString(issue.Message)))
+	}
+
+	for _, issue := range r.Warnings {
+		body = append(body, fmt.Sprintf("<li>Warning: %s %s %s</li>", html.EscapeString(issue.Source), html.EscapeString(issue.ID), html.EscapeString(issue.Message)))
+	}
+
+	if len(body) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("<ul style=\"font-size: 0.8rem;\">%s</ul>", strings.Join(body, "\n"))
+}
+
+// Partial reports whether some of the requested data could not be retrieved.
+func (r glinksReport) Partial() bool {
+	return len(r.Errors) > 0
+}
+
+// reportMissing records every ID that is not in found. The IDs are errors if
+// the source failed and warnings otherwise.
+func (r *glinksReport) reportMissing(source string, ids []string, found map[string]bool, err error) {
+	message := "no entry found"
+
+	if offline {
+		message = "not cached"
+	}
+
+	for _, id := range ids {
+		if found[id] {
+			continue
+		}
+
+		if err != nil {
+			r.Error(source, id, errNotRetrieved)
+		} else {
+			r.Warn(source, id, message)
+		}
+	}
+}
+
+// foundUniprot returns the set of ids for which list holds an entry, either
+// by accession, versioned accession or entry name.
+func foundUniprot(list []uniprot, ids []string) map[string]bool {
+	found := make(map[string]bool)
+
+	for _, item := range list {
+		for _, accession := range item.Accession {
+			found[accession] = true
+		}
+
+		for _, name := range item.Name {
+			found[name] = true
+		}
+	}
+
+	for _, id := range ids {
+		if stem, _ := splitVersion("UniProtKB-AC", id); found[stem] {
+			found[id] = true
+		}
+	}
+
+	return found
+}
+
+// getGlinks returns the records of the given UniProt and KEGG gene IDs. KEGG
+// genes are looked up in LinkDB directly, so that genes without a UniProt
+// entry get a record too.
+func getGlinks(ctx context.Context, queries []string) (ret []glinks, report glinksReport) {
+	var ids, genes []string
+
+	for _, query := range queries {
+		if _, ok := parseKeggGeneID(query); ok {
+			genes = append(genes, query)
+		} else {
+			ids = append(ids, query)
+		}
+	}
+
+	var list []uniprot
+	var err error
+
+	if len(ids) > 0 {
+		list, err = getUniprot(ctx, ids)
+	}
 
 	if err != nil {
 		log.Printf("Failed to get UniProt entries: %s", err)
+		report.Error("UniProt", "", err)
 	}
 
-	var keggIDs []string
+	report.reportMissing("UniProt", ids, foundUniprot(list, ids), err)
+
+	keggIDs := append([]string(nil), genes...)
 
 	for _, item := range list {
 		for _, dbReference := range item.DbReference {
@@ -153,15 +290,30 @@ func getGlinks(ids []string) (ret []glinks, err error) {
 		}
 	}
 
-	_, err = getLinkDB(keggIDs)
+	links, err := getLinkDB(ctx, keggIDs)
 
 	if err != nil {
 		log.Printf("Failed to get LinkDB entries: %s", err)
+		report.Error("LinkDB", "", err)
+	}
+
+	found := make(map[string]bool)
+
+	for _, item := range links {
+		found[item.ID] = true
 	}
 
+	report.reportMissing("LinkDB", keggIDs, found, err)
+
 	for _, item := range list {
 		ret = append(ret, item.ToGlinks())
 	}
 
-	return ret, nil
+	for _, item := range links {
+		if containsString(genes, item.ID) {
+			ret = append(ret, glinks{ID: item.ID, Links: item.ToGlinks()})
+		}
+	}
+
+	return ret, report
 }
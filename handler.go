@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo"
 )
@@ -11,51 +16,330 @@ func init() {
 	e.GET("/favicon.ico", func(c echo.Context) (err error) {
 		return c.NoContent(http.StatusNotFound)
 	})
+	e.GET("/reverse/:query", reverseHandler)
+	e.GET("/ko/:id", keggOrthologyHandler)
 	e.GET("/:query", handler)
 }
 
+type reverseOut struct {
+	Uniprot  string              `json:"uniprot"`
+	Mappings map[string][]string `json:"mappings"`
+}
+
+func reverseHandler(c echo.Context) error {
+	queries := strings.Split(c.Param("query"), ",")
+
+	var namespaces []string
+
+	if param := c.QueryParam("namespace"); len(param) > 0 {
+		namespaces = strings.Split(param, ",")
+	}
+
+	var out []reverseOut
+
+	for _, query := range queries {
+		out = append(out, reverseOut{
+			Uniprot:  query,
+			Mappings: findReverseMapping(query, namespaces),
+		})
+	}
+
+	if c.Request().Header.Get("Accept") == "application/json" {
+		return c.JSON(http.StatusOK, out)
+	}
+
+	response := c.Response()
+
+	response.Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
+	response.WriteHeader(http.StatusOK)
+
+	for _, item := range out {
+		list := glinks{ID: item.Uniprot}
+
+		for namespace, ids := range item.Mappings {
+			host, err := getDBHost(namespace)
+
+			for _, id := range ids {
+				if err != nil {
+					list.Links = append(list.Links, createGlinksLink(namespace, id, "", id))
+				} else {
+					link := strings.Replace(host, ":id", id, -1)
+					list.Links = append(list.Links, createGlinksLink(namespace, id, link, ""))
+				}
+			}
+		}
+
+		if _, err := response.Write([]byte(list.HTML())); err != nil {
+			return err
+		}
+	}
+
+	response.Flush()
+
+	return nil
+}
+
+// requestTimeout bounds the time spent on upstream fetches for a request.
+// Results gathered until then are returned and marked as partial.
+var requestTimeout = parseDurationEnv("REQUEST_TIMEOUT", 60*time.Second)
+
+type keggReferenceOut struct {
+	Reference string `json:"reference"`
+	Authors   string `json:"authors,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Journal   string `json:"journal,omitempty"`
+}
+
+type keggOrthologyOut struct {
+	ID         string              `json:"id"`
+	Symbol     string              `json:"symbol,omitempty"`
+	Name       string              `json:"name,omitempty"`
+	Definition string              `json:"definition,omitempty"`
+	Links      map[string][]string `json:"links,omitempty"`
+	Brite      []string            `json:"brite,omitempty"`
+	DBLinks    map[string][]string `json:"dblinks,omitempty"`
+	Genes      map[string][]string `json:"genes,omitempty"`
+	References []keggReferenceOut  `json:"references,omitempty"`
+}
+
+func keggOrthologyHandler(c echo.Context) error {
+	ko, err := getKeggOrthology(strings.TrimPrefix(c.Param("id"), "ko:"))
+
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if c.Request().Header.Get("Accept") == "application/json" {
+		out := keggOrthologyOut{
+			ID:         ko.ID,
+			Symbol:     ko.Symbol,
+			Name:       ko.Name,
+			Definition: ko.Description,
+			Links:      make(map[string][]string),
+			Brite:      ko.Brite,
+			DBLinks:    ko.DBLinks,
+			Genes:      ko.Genes,
+		}
+
+		for _, link := range ko.Links {
+			out.Links[link.Domain] = append(out.Links[link.Domain], link.ID)
+		}
+
+		for _, reference := range ko.References {
+			out.References = append(out.References, keggReferenceOut(reference))
+		}
+
+		return c.JSON(http.StatusOK, out)
+	}
+
+	list := glinks{
+		ID:    ko.ID,
+		Links: keggLink{ID: ko.ID, Domain: "ORTHOLOGY", Description: ko.Description}.ToGlinks(),
+	}
+
+	for _, link := range ko.Links {
+		if link.Domain == "PATHWAY" || link.Domain == "DISEASE" {
+			list.Links = append(list.Links, link.ToGlinks()...)
+		}
+	}
+
+	return c.HTML(http.StatusOK, list.HTML())
+}
+
+// responseFormat returns the format requested through the format query
+// parameter, or else the Accept header: "json", "gff3", "fasta" or "html".
+func responseFormat(c echo.Context) string {
+	if format := c.QueryParam("format"); len(format) > 0 {
+		return format
+	}
+
+	switch c.Request().Header.Get("Accept") {
+	case "application/json":
+		return "json"
+	case "text/x-gff3":
+		return "gff3"
+	case "text/x-fasta":
+		return "fasta"
+	}
+
+	return "html"
+}
+
+// exportFormats maps the formats that export the UniProt entries as a file to
+// their content type, header, comment prefix and conversion. Formats with a
+// comment prefix list the issues of the request in comment lines.
+var exportFormats = map[string]struct {
+	ContentType string
+	Header      string
+	Comment     string
+	Convert     func(uniprot) string
+}{
+	"gff3":  {"text/x-gff3; charset=UTF-8", "##gff-version 3\n", "#", uniprot.GFF3},
+	"fasta": {"text/x-fasta; charset=UTF-8", "", "", uniprot.FASTA},
+}
+
+// exportHandler writes the UniProt entries in ids in the given export format.
+// IDs without an entry are listed in the X-Glinks-Missing header.
+func exportHandler(ctx context.Context, c echo.Context, format string, ids []string) error {
+	export := exportFormats[format]
+
+	var accessions []string
+
+	for _, id := range ids {
+		if _, ok := parseKeggGeneID(id); !ok {
+			accessions = append(accessions, id)
+		}
+	}
+
+	var list []uniprot
+	var err error
+	var report glinksReport
+
+	if len(accessions) > 0 {
+		list, err = getUniprot(ctx, accessions)
+	}
+
+	if err != nil {
+		log.Printf("Failed to get UniProt entries: %s", err)
+		report.Error("UniProt", "", err)
+	}
+
+	found := foundUniprot(list, ids)
+
+	report.reportMissing("UniProt", ids, found, err)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		c.Response().Header().Set("X-Glinks-Timeout", "true")
+		report.Error("G-Links", "", errRequestTimeout)
+	}
+
+	var missing []string
+
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		c.Response().Header().Set("X-Glinks-Missing", strings.Join(missing, ","))
+	}
+
+	if report.Partial() {
+		c.Response().Header().Set("X-Glinks-Partial", "true")
+
+		if len(list) == 0 {
+			return c.String(http.StatusBadGateway, report.Errors[0].Message)
+		}
+	}
+
+	response := c.Response()
+
+	response.Header().Set(echo.HeaderContentType, export.ContentType)
+	response.WriteHeader(http.StatusOK)
+
+	if _, err := io.WriteString(response, export.Header); err != nil {
+		return err
+	}
+
+	if len(export.Comment) > 0 {
+		for _, issue := range append(report.Errors, report.Warnings...) {
+			if _, err := fmt.Fprintf(response, "%s %s\n", export.Comment, issue); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range list {
+		if _, err := io.WriteString(response, export.Convert(item)); err != nil {
+			return err
+		}
+	}
+
+	response.Flush()
+
+	return nil
+}
+
 func handler(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), requestTimeout)
+	defer cancel()
+
+	format := responseFormat(c)
+
 	queries := strings.Split(c.Param("query"), ",")
 
 	var converted []string
 
 	for _, query := range queries {
+		// KEGG genes map to UniProt through the KEGG store if possible and
+		// are looked up in LinkDB alone otherwise.
+		gene, isGene := parseKeggGeneID(query)
+
+		if isGene {
+			query = "KEGG:" + gene
+		}
+
 		ids, err := findMapping(query)
 
-		if err != nil {
-			converted = append(converted, query)
-		} else {
+		switch {
+		case err == nil:
 			converted = append(converted, ids...)
+		case isGene:
+			converted = append(converted, gene)
+		default:
+			converted = append(converted, query)
 		}
 	}
 
-	list, err := getGlinks(converted)
+	if _, ok := exportFormats[format]; ok {
+		return exportHandler(ctx, c, format, converted)
+	}
 
-	if err != nil {
-		return err
+	list, report := getGlinks(ctx, converted)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		c.Response().Header().Set("X-Glinks-Timeout", "true")
+		report.Error("G-Links", "", errRequestTimeout)
 	}
 
-	if c.Request().Header.Get("Accept") == "application/json" {
-		var out []glinksOut
+	// Only fail the request outright if nothing at all could be retrieved.
+	status := http.StatusOK
+
+	if report.Partial() {
+		c.Response().Header().Set("X-Glinks-Partial", "true")
+
+		if len(list) == 0 {
+			status = http.StatusBadGateway
+		}
+	}
+
+	if format == "json" {
+		out := glinksResponse{
+			Results:      make([]glinksOut, 0),
+			Partial:      report.Partial(),
+			glinksReport: report,
+		}
 
 		for _, item := range list {
 			for i := range item.Links {
 				item.Links[i].Flag = hasNone
 			}
 
-			out = append(out, glinksOut{
+			out.Results = append(out.Results, glinksOut{
 				Uniprot: item.ID,
+				Label:   item.Label,
 				Results: item.Links,
 			})
 		}
 
-		return c.JSON(http.StatusOK, out)
+		return c.JSON(status, out)
 	}
 
 	response := c.Response()
 
 	response.Header().Set(echo.HeaderContentType, echo.MIMETextHTML)
-	response.WriteHeader(http.StatusOK)
+	response.WriteHeader(status)
 
 	for _, item := range list {
 		if _, err := response.Write([]byte(item.HTML())); err != nil {
@@ -65,6 +349,10 @@ func handler(c echo.Context) error {
 		c.Response().Flush()
 	}
 
+	if _, err := response.Write([]byte(report.HTML())); err != nil {
+		return err
+	}
+
 	c.Response().Flush()
 
 	return nil
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/asdine/storm"
+)
+
+// record makes the handler tests record their fixtures from the mock upstream
+// serving the mock directory instead of replaying them.
+var record = flag.Bool("record", false, "record fixtures from the mock upstream")
+
+const testFixtures = "testdata/fixtures"
+
+// mockTransport sends every request to the mock upstream server, keeping the
+// original URL for the recorded fixtures.
+type mockTransport struct {
+	URL *url.URL
+}
+
+func (m mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = m.URL.Scheme
+	req.URL.Host = m.URL.Host
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// setupHandlerTest opens an empty cache and routes upstream requests to the
+// fixtures.
+func setupHandlerTest(t *testing.T) {
+	var err error
+
+	if db, err = storm.Open(filepath.Join(t.TempDir(), "glinks.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	mappings = make(map[string]*storm.DB)
+
+	if !*record {
+		upstream.SetTransport(newFixtureTransport(testFixtures, false))
+		return
+	}
+
+	m, err := newMockUpstream("mock")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(m.Echo())
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+
+	transport := newFixtureTransport(testFixtures, true)
+	transport.Transport = mockTransport{URL: target}
+
+	upstream.SetTransport(transport)
+}
+
+func serveTest(t *testing.T, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandlerJSON(t *testing.T) {
+	setupHandlerTest(t)
+
+	rec := serveTest(t, "/P01308", "application/json")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var out glinksResponse
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Partial || len(out.Errors) > 0 {
+		t.Errorf("unexpected errors: %v", out.Errors)
+	}
+
+	if len(out.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(out.Results))
+	}
+
+	result := out.Results[0]
+
+	if result.Uniprot != "P01308" || result.Label != "INS" {
+		t.Errorf("got record %s labeled %q, want P01308 labeled INS", result.Uniprot, result.Label)
+	}
+
+	found := make(map[string]bool)
+
+	for _, link := range result.Results {
+		found[link.DB] = true
+
+		if link.DB == "Feature (sequence variant)" && (link.Start != 48 || link.End != 48) {
+			t.Errorf("variant at %d-%d, want 48-48", link.Start, link.End)
+		}
+	}
+
+	for _, db := range []string{"Gene Name (Primary)", "Feature (sequence variant)", "Sequence Length", "KEGG_GENE"} {
+		if !found[db] {
+			t.Errorf("no %s in results", db)
+		}
+	}
+}
+
+func TestHandlerEntryName(t *testing.T) {
+	setupHandlerTest(t)
+
+	rec := serveTest(t, "/INS_HUMAN", "application/json")
+
+	var out glinksResponse
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Results) != 1 || out.Results[0].Uniprot != "P01308" {
+		t.Errorf("INS_HUMAN resolved to %v, want P01308", out.Results)
+	}
+}
+
+func TestHandlerExport(t *testing.T) {
+	setupHandlerTest(t)
+
+	tests := []struct {
+		target  string
+		accept  string
+		want    []string
+		missing string
+	}{
+		{"/P01308?format=gff3", "", []string{"##gff-version 3\n", "P01308\tUniProtKB\tSequence variant\t48\t48\t"}, ""},
+		{"/P01308", "text/x-fasta", []string{">sp|P01308|INS_HUMAN Insulin OS=Homo sapiens OX=9606 GN=INS SV=1\nMALWMRLLPLL"}, ""},
+		{"/P01308,P99999?format=gff3", "", []string{"# UniProt P99999 no entry found\n", "P01308\tUniProtKB\tChain\t"}, "P99999"},
+	}
+
+	for _, test := range tests {
+		rec := serveTest(t, test.target, test.accept)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", test.target, rec.Code, http.StatusOK)
+		}
+
+		if missing := rec.Header().Get("X-Glinks-Missing"); missing != test.missing {
+			t.Errorf("%s: X-Glinks-Missing = %q, want %q", test.target, missing, test.missing)
+		}
+
+		for _, want := range test.want {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("%s: body %q does not contain %q", test.target, rec.Body, want)
+			}
+		}
+	}
+}
+
+func TestHandlerUpstreamError(t *testing.T) {
+	setupHandlerTest(t)
+
+	rec := serveTest(t, "/status:503", "application/json")
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+
+	if partial := rec.Header().Get("X-Glinks-Partial"); partial != "true" {
+		t.Errorf("X-Glinks-Partial = %q, want %q", partial, "true")
+	}
+
+	var out glinksResponse
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.Partial || len(out.Errors) == 0 {
+		t.Errorf("partial = %t with errors %v, want errors", out.Partial, out.Errors)
+	}
+}
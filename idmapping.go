@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/asdine/storm"
@@ -13,53 +12,142 @@ import (
 // Mapping provides a mapping from some ID to a list of UniProt IDs
 type Mapping struct {
 	ID        string `storm:"id"`
+	Stem      string `storm:"index"`
+	Release   string `storm:"index"`
 	Relations []string
 }
 
+// ReverseMapping provides a mapping from a UniProt ID to the IDs of a single
+// namespace that map to it
+type ReverseMapping Mapping
+
+// versionSeparators lists the namespaces whose identifiers may carry a
+// version suffix, together with the separator preceding the version number.
+var versionSeparators = map[string]string{
+	"RefSeq":       ".",
+	"RefSeq_NT":    ".",
+	"Ensembl":      ".",
+	"Ensembl_TRS":  ".",
+	"Ensembl_PRO":  ".",
+	"EMBL":         ".",
+	"EMBL-CDS":     ".",
+	"UniProtKB-AC": "-",
+}
+
+// splitVersion separates an ID of the given namespace into its unversioned
+// stem and its version number. The version is empty if the ID has none.
+func splitVersion(namespace, id string) (string, string) {
+	sep, ok := versionSeparators[namespace]
+
+	if !ok {
+		return id, ""
+	}
+
+	i := strings.LastIndex(id, sep)
+
+	if i <= 0 || i+len(sep) == len(id) {
+		return id, ""
+	}
+
+	version := id[i+len(sep):]
+
+	for _, c := range version {
+		if c < '0' || '9' < c {
+			return id, ""
+		}
+	}
+
+	return id[:i], version
+}
+
+func lookupMapping(namespace string, v *storm.DB, query string) ([]string, error) {
+	stem, version := splitVersion(namespace, query)
+
+	var items []Mapping
+
+	// Stores built before IDs were indexed by stem have no Stem values, so
+	// fall back to the exact ID until they are rebuilt.
+	if err := v.Find("Stem", stem, &items); err != nil {
+		var item Mapping
+
+		if err := v.One("ID", query, &item); err != nil {
+			return nil, err
+		}
+
+		return item.Relations, nil
+	}
+
+	// An exact match takes precedence over other versions of the same ID.
+	if len(version) > 0 {
+		for _, item := range items {
+			if item.ID == query {
+				return item.Relations, nil
+			}
+		}
+	}
+
+	var relations []string
+
+	seen := make(map[string]bool)
+
+	for _, item := range items {
+		for _, relation := range item.Relations {
+			if !seen[relation] {
+				seen[relation] = true
+				relations = append(relations, relation)
+			}
+		}
+	}
+
+	return relations, nil
+}
+
 func findMapping(query string) ([]string, error) {
 	tmp := strings.Split(query, ":")
 
 	if len(tmp) > 1 {
 		k := tmp[0]
-		q := strings.Join(tmp[1:] ,":")
+		q := strings.Join(tmp[1:], ":")
 
 		if v, ok := mappings[k]; ok {
-			var item Mapping
-
-			if err := v.One("ID", q, &item); err == nil {
-				return item.Relations, nil
+			if relations, err := lookupMapping(k, v, q); err == nil {
+				return relations, nil
 			}
 		}
 	}
 
-  for k, v := range mappings {
-    var item Mapping
-
-    if err := v.One("ID", query, &item); err != nil {
-      if k == "RefSeq_NT" || k == "RefSeq" {
-        for i := 0; i < 10; i++ {
-          version := fmt.Sprintf("%s.%d", query, i)
-
-          if err = v.One("ID", version, &item); err == nil {
-						return item.Relations, nil
-					}
-				}
-      }
-    } else {
-      return item.Relations, nil
-    }
-  }
-
-  return nil, errConversionFailed
+	for k, v := range mappings {
+		if relations, err := lookupMapping(k, v, query); err == nil {
+			return relations, nil
+		}
+	}
+
+	return nil, errConversionFailed
 }
 
-func createMappings() map[string]*storm.DB {
-	mappingPath := os.Getenv("MAPPING_PATH")
+func findReverseMapping(accession string, namespaces []string) map[string][]string {
+	stem, _ := splitVersion("UniProtKB-AC", accession)
 
-	if len(mappingPath) == 0 {
-		mappingPath = "mappings"
+	ret := make(map[string][]string)
+
+	for k, v := range mappings {
+		if len(namespaces) > 0 && !containsString(namespaces, k) {
+			continue
+		}
+
+		var item ReverseMapping
+
+		if err := v.One("ID", stem, &item); err == nil {
+			ret[k] = item.Relations
+		}
 	}
 
+	return ret
+}
+
+func createMappings() map[string]*storm.DB {
+	mappingPath := getEnv("MAPPING_PATH", "mappings")
+
 	files, err := ioutil.ReadDir(mappingPath)
 
 	if err != nil {
@@ -85,6 +173,13 @@ func createMappings() map[string]*storm.DB {
 			log.Fatal(err)
 		}
 
+		// Stores built before IDs were indexed by stem only match exact IDs.
+		var item Mapping
+
+		if err := db.Select().First(&item); err == nil && len(item.Stem) == 0 {
+			log.Printf("%s/%s has no stem index and only matches exact IDs: rebuild it with build-mappings", mappingPath, name)
+		}
+
 		mappings[name[:len(name)-3]] = db
 	}
 
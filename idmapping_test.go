@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		namespace string
+		id        string
+		stem      string
+		version   string
+	}{
+		{"RefSeq", "NM_000207.3", "NM_000207", "3"},
+		{"RefSeq", "NM_000207", "NM_000207", ""},
+		{"Ensembl", "ENSG00000254647.17", "ENSG00000254647", "17"},
+		{"Ensembl_PRO", "ENSP00000250971.3", "ENSP00000250971", "3"},
+		{"UniProtKB-AC", "P01308-2", "P01308", "2"},
+		{"UniProtKB-AC", "P01308", "P01308", ""},
+		{"RefSeq", "NM_000207.x", "NM_000207.x", ""},
+		{"RefSeq", "NM_000207.", "NM_000207.", ""},
+		{"RefSeq", ".3", ".3", ""},
+		{"UniProtKB-AC", "P01308-2a", "P01308-2a", ""},
+		{"GeneID", "3630.1", "3630.1", ""},
+	}
+
+	for _, test := range tests {
+		stem, version := splitVersion(test.namespace, test.id)
+
+		if stem != test.stem || version != test.version {
+			t.Errorf("splitVersion(%q, %q) = %q, %q, want %q, %q", test.namespace, test.id, stem, version, test.stem, test.version)
+		}
+	}
+}
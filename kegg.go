@@ -2,9 +2,99 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+// keggDomain describes a database that KEGG links to.
+type keggDomain struct {
+	Prefix string // database prefix in LinkDB responses
+	DB     string // name shown in G-Links results
+	Host   string // entry in the urls file holding the URL template
+}
+
+// keggDomains maps the domain names used in keggLink to their databases.
+var keggDomains = map[string]keggDomain{
+	"GENE":           {"", "KEGG_GENE", "KEGG"},
+	"PATHWAY":        {"path", "KEGG_PATHWAY", "KEGG"},
+	"DISEASE":        {"ds", "KEGG_DISEASE", "KEGG"},
+	"ORTHOLOGY":      {"ko", "KEGG_ORTHOLOGY", "KEGG"},
+	"BRITE":          {"br", "KEGG_BRITE", "KEGG"},
+	"MODULE":         {"md", "KEGG_MODULE", "KEGG"},
+	"ENZYME":         {"ec", "KEGG_ENZYME", "KEGG_ENZYME"},
+	"REACTION":       {"rn", "KEGG_REACTION", "KEGG"},
+	"COMPOUND":       {"cpd", "KEGG_COMPOUND", "KEGG"},
+	"DRUG":           {"dr", "KEGG_DRUG", "KEGG"},
+	"NETWORK":        {"ne", "KEGG_NETWORK", "KEGG"},
+	"NCBI-GENEID":    {"ncbi-geneid", "GeneID", "GeneID"},
+	"NCBI-PROTEINID": {"ncbi-proteinid", "RefSeq", "RefSeq"},
+	"UNIPROT":        {"up", "UniProtKB-AC", "UniProtKB-AC"},
+	"PUBMED":         {"pubmed", "PubMed", "PubMed"},
+}
+
+// keggGeneID matches KEGG gene IDs made of an organism code or T number and
+// the gene name, e.g. hsa:7157 or eco:b0002, optionally prefixed by "kegg:".
+var keggGeneID = regexp.MustCompile(`^(?i:kegg:)?([a-z]{3,4}|T\d{5}):(\S+)$`)
+
+// keggDatabasePrefixes lists the prefixes of KEGG databases and of other
+// databases whose IDs look like KEGG gene IDs, e.g. path:map04910 or
+// pdb:1ZNI, and are therefore not taken for organism codes.
+var keggDatabasePrefixes = map[string]bool{
+	"path": true,
+	"br":   true,
+	"md":   true,
+	"ko":   true,
+	"gn":   true,
+	"cpd":  true,
+	"gl":   true,
+	"rn":   true,
+	"rc":   true,
+	"ec":   true,
+	"ne":   true,
+	"ds":   true,
+	"dr":   true,
+	"dg":   true,
+	"drug": true,
+	"ev":   true,
+	"up":   true,
+	"pdb":  true,
+	"omim": true,
+	"hgnc": true,
+	"pfam": true,
+}
+
+// parseKeggGeneID returns the KEGG gene ID in query without the "kegg:"
+// prefix, or false if query is not a KEGG gene ID.
+func parseKeggGeneID(query string) (string, bool) {
+	match := keggGeneID.FindStringSubmatch(query)
+
+	if match == nil || keggDatabasePrefixes[match[1]] {
+		return "", false
+	}
+
+	return match[1] + ":" + match[2], true
+}
+
+// referencePathwayPrefixes lists the pathway ID prefixes of reference maps
+// (map04110), KO pathways (ko04110), and the EC and reaction variants of the
+// reference maps. Any other prefix is an organism code (hsa04110).
+var referencePathwayPrefixes = map[string]bool{
+	"map": true,
+	"ko":  true,
+	"ec":  true,
+	"rn":  true,
+}
+
+// collapsePathways keeps a single pathway per map number in the results of a
+// gene, preferring the pathway of the gene's organism.
+var collapsePathways, _ = strconv.ParseBool(os.Getenv("KEGG_COLLAPSE_PATHWAYS"))
+
+func keggPathwayPrefix(id string) string {
+	return strings.TrimSuffix(id, keggMapNumber(id))
+}
+
 type keggLink struct {
 	ID          string
 	Domain      string
@@ -12,15 +102,31 @@ type keggLink struct {
 }
 
 func (k keggLink) ToGlinks() []glinksLink {
-	link, _ := getDBHost("KEGG")
+	domain, ok := keggDomains[k.Domain]
+
+	if !ok {
+		domain = keggDomain{DB: fmt.Sprintf("KEGG_%s", k.Domain), Host: "KEGG"}
+	}
+
+	if prefix := keggPathwayPrefix(k.ID); k.Domain == "PATHWAY" && referencePathwayPrefixes[prefix] {
+		domain.DB = fmt.Sprintf("KEGG_PATHWAY_%s", strings.ToUpper(prefix))
+	}
+
+	link, _ := getDBHost(domain.Host)
 	link = strings.Replace(link, ":id", k.ID, -1)
 
-	item := createGlinksLink(fmt.Sprintf("KEGG_%s", k.Domain), k.ID, link, "")
+	item := createGlinksLink(domain.DB, k.ID, link, "")
 
 	if len(k.Description) > 0 {
 		item.Text = k.Description
 		item.Flag |= hasText
 	}
 
+	if k.Domain == "ORTHOLOGY" {
+		if ko, err := getKeggOrthology(k.ID); err == nil {
+			return append([]glinksLink{item}, ko.ToGlinks()...)
+		}
+	}
+
 	return []glinksLink{item}
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+)
+
+// keggSection is a section of a KEGG DBGET flat file entry, holding the
+// text of its first line and every continuation line. Raw holds the same
+// lines with their indentation past the name column, which carries the
+// hierarchy in sections like BRITE. Indented sections such as AUTHORS within
+// REFERENCE are kept as subsections.
+type keggSection struct {
+	Name        string
+	Lines       []string
+	Raw         []string
+	Subsections []keggSection
+}
+
+func (s *keggSection) add(line, text string) {
+	if len(text) == 0 {
+		return
+	}
+
+	raw := text
+
+	if len(line) > keggHeaderWidth && len(strings.TrimSpace(line[:keggHeaderWidth])) == 0 {
+		raw = line[keggHeaderWidth:]
+	}
+
+	s.Lines = append(s.Lines, text)
+	s.Raw = append(s.Raw, raw)
+}
+
+// Text returns the lines of the section joined by spaces.
+func (s keggSection) Text() string {
+	return strings.Join(s.Lines, " ")
+}
+
+// Items splits every line of the section into an ID and its description, as
+// in the PATHWAY or DISEASE sections.
+func (s keggSection) Items(domain string) (links []keggLink) {
+	for _, line := range s.Lines {
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		links = append(links, keggLink{
+			ID:          fields[0],
+			Domain:      domain,
+			Description: strings.Join(fields[1:], " "),
+		})
+	}
+	return links
+}
+
+// keggEntry is a single entry of a KEGG DBGET flat file.
+type keggEntry struct {
+	Sections []keggSection
+}
+
+// Section returns the first section of the given name.
+func (e keggEntry) Section(name string) (keggSection, bool) {
+	for _, section := range e.Sections {
+		if section.Name == name {
+			return section, true
+		}
+	}
+	return keggSection{}, false
+}
+
+// All returns every section of the given name, e.g. each REFERENCE.
+func (e keggEntry) All(name string) (sections []keggSection) {
+	for _, section := range e.Sections {
+		if section.Name == name {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// Text returns the joined lines of the first section of the given name.
+func (e keggEntry) Text(name string) string {
+	section, _ := e.Section(name)
+	return section.Text()
+}
+
+// ID returns the identifier given in the ENTRY section.
+func (e keggEntry) ID() string {
+	section, _ := e.Section("ENTRY")
+
+	if len(section.Lines) == 0 {
+		return ""
+	}
+
+	fields := strings.Fields(section.Lines[0])
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// Links returns the items of the named section as links of the given domain.
+func (e keggEntry) Links(name, domain string) []keggLink {
+	section, _ := e.Section(name)
+	return section.Items(domain)
+}
+
+// keggHeaderWidth is the width of the column holding section names.
+const keggHeaderWidth = 12
+
+// isKeggSectionName reports whether s looks like a section name, which is
+// written in upper case letters and underscores.
+func isKeggSectionName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < 'A' || 'Z' < c) && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseKeggEntry parses a single KEGG DBGET flat file entry of any database,
+// such as KO, pathway, disease or gene entries. Only KO entries are fetched
+// for now; pathway and disease links come from LinkDB and the KO entries.
+// Lines after a /// terminator are ignored.
+func parseKeggEntry(text string) (entry keggEntry) {
+	var current *keggSection
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \r")
+
+		if line == "///" {
+			break
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		fields := strings.Fields(trimmed)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		indent := len(line) - len(trimmed)
+		value := strings.TrimSpace(strings.TrimPrefix(trimmed, fields[0]))
+
+		switch {
+		case indent == 0:
+			entry.Sections = append(entry.Sections, keggSection{Name: fields[0]})
+			current = &entry.Sections[len(entry.Sections)-1]
+			current.add("", value)
+
+		case indent < keggHeaderWidth && isKeggSectionName(fields[0]) && len(entry.Sections) > 0:
+			parent := &entry.Sections[len(entry.Sections)-1]
+			parent.Subsections = append(parent.Subsections, keggSection{Name: fields[0]})
+			current = &parent.Subsections[len(parent.Subsections)-1]
+			current.add("", value)
+
+		case current != nil:
+			current.add(line, trimmed)
+		}
+	}
+
+	return entry
+}
+
+// parseKeggEntries parses a KEGG DBGET flat file holding any number of
+// entries terminated by ///.
+func parseKeggEntries(text string) (entries []keggEntry) {
+	for _, part := range strings.Split(text, "\n///") {
+		if entry := parseKeggEntry(part); len(entry.Sections) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeggEntryContinuation(t *testing.T) {
+	entry := parseKeggEntry("" +
+		"ENTRY       K04526                      KO\n" +
+		"PATHWAY     map04910  Insulin signaling pathway\n" +
+		"            map04930  Type II diabetes mellitus\n" +
+		"DEFINITION  insulin\n" +
+		"            precursor\n")
+
+	links := entry.Links("PATHWAY", "PATHWAY")
+
+	want := []keggLink{
+		{ID: "map04910", Domain: "PATHWAY", Description: "Insulin signaling pathway"},
+		{ID: "map04930", Domain: "PATHWAY", Description: "Type II diabetes mellitus"},
+	}
+
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("Links(PATHWAY) = %v, want %v", links, want)
+	}
+
+	if text := entry.Text("DEFINITION"); text != "insulin precursor" {
+		t.Errorf("Text(DEFINITION) = %q, want %q", text, "insulin precursor")
+	}
+
+	if id := entry.ID(); id != "K04526" {
+		t.Errorf("ID() = %q, want %q", id, "K04526")
+	}
+}
+
+func TestParseKeggEntryTerminator(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		count int
+		ids   []string
+	}{
+		{"empty", "", 0, nil},
+		{"terminator only", "///\n", 0, nil},
+		{"single", "ENTRY       K00001\nNAME        E1.1.1.1\n///\n", 1, []string{"K00001"}},
+		{"no terminator", "ENTRY       K00001\n", 1, []string{"K00001"}},
+		{"multiple", "ENTRY       K00001\n///\nENTRY       K00002\n///\n", 2, []string{"K00001", "K00002"}},
+		{"trailing whitespace", "ENTRY       K00001\n///  \r\n\n", 1, []string{"K00001"}},
+	}
+
+	for _, test := range tests {
+		entries := parseKeggEntries(test.text)
+
+		if len(entries) != test.count {
+			t.Errorf("%s: got %d entries, want %d", test.name, len(entries), test.count)
+			continue
+		}
+
+		for i, id := range test.ids {
+			if entries[i].ID() != id {
+				t.Errorf("%s: entry %d has ID %q, want %q", test.name, i, entries[i].ID(), id)
+			}
+		}
+	}
+
+	entry := parseKeggEntry("ENTRY       K00001\n///\nNAME        ignored\n")
+
+	if _, ok := entry.Section("NAME"); ok {
+		t.Errorf("parseKeggEntry kept a section after ///")
+	}
+}
+
+func TestParseKeggEntrySubsections(t *testing.T) {
+	entry := parseKeggEntry("" +
+		"ENTRY       K04526\n" +
+		"REFERENCE   PMID:1234\n" +
+		"  AUTHORS   Doe J, Roe R\n" +
+		"  TITLE     Insulin\n" +
+		"            revisited\n" +
+		"  JOURNAL   J Test 1:1-2 (2000)\n" +
+		"REFERENCE   PMID:5678\n" +
+		"  TITLE     Another\n")
+
+	references := entry.All("REFERENCE")
+
+	if len(references) != 2 {
+		t.Fatalf("got %d references, want 2", len(references))
+	}
+
+	if text := references[0].Text(); text != "PMID:1234" {
+		t.Errorf("reference text = %q, want %q", text, "PMID:1234")
+	}
+
+	var names []string
+
+	for _, sub := range references[0].Subsections {
+		names = append(names, sub.Name)
+	}
+
+	if want := []string{"AUTHORS", "TITLE", "JOURNAL"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("subsections = %v, want %v", names, want)
+	}
+
+	if text := references[0].Subsections[1].Text(); text != "Insulin revisited" {
+		t.Errorf("TITLE = %q, want %q", text, "Insulin revisited")
+	}
+
+	if n := len(references[1].Subsections); n != 1 {
+		t.Errorf("second reference has %d subsections, want 1", n)
+	}
+}
+
+func TestParseKeggEntryDatabases(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		id      string
+		section string
+		want    []string
+	}{
+		{
+			"gene",
+			"" +
+				"ENTRY       3630              CDS       T01001\n" +
+				"SYMBOL      INS, IDDM, IDDM1, IDDM2, ILPR, IRDN, MODY10\n" +
+				"ORTHOLOGY   K04526  insulin\n" +
+				"PATHWAY     hsa04910  Insulin signaling pathway\n" +
+				"            hsa04930  Type II diabetes mellitus\n" +
+				"///\n",
+			"3630",
+			"PATHWAY",
+			[]string{"hsa04910", "hsa04930"},
+		},
+		{
+			"pathway",
+			"" +
+				"ENTRY       map04910                    Pathway\n" +
+				"NAME        Insulin signaling pathway\n" +
+				"DISEASE     H00408  Type A insulin resistance\n" +
+				"ORTHOLOGY   K04526  insulin\n" +
+				"            K04527  insulin receptor [EC:2.7.10.1]\n" +
+				"///\n",
+			"map04910",
+			"ORTHOLOGY",
+			[]string{"K04526", "K04527"},
+		},
+		{
+			"disease",
+			"" +
+				"ENTRY       H00408                      Disease\n" +
+				"NAME        Type A insulin resistance\n" +
+				"GENE        INSR [HSA:3643] [KO:K04527]\n" +
+				"PATHWAY     hsa04910  Insulin signaling pathway\n" +
+				"///\n",
+			"H00408",
+			"GENE",
+			[]string{"INSR"},
+		},
+	}
+
+	for _, test := range tests {
+		entries := parseKeggEntries(test.text)
+
+		if len(entries) != 1 {
+			t.Errorf("%s: got %d entries, want 1", test.name, len(entries))
+			continue
+		}
+
+		if id := entries[0].ID(); id != test.id {
+			t.Errorf("%s: ID() = %q, want %q", test.name, id, test.id)
+		}
+
+		var ids []string
+
+		for _, link := range entries[0].Links(test.section, test.section) {
+			ids = append(ids, link.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%s: Links(%s) = %v, want %v", test.name, test.section, ids, test.want)
+		}
+	}
+}
+
+func FuzzParseKeggEntry(f *testing.F) {
+	buf, err := ioutil.ReadFile("mock/kegg/K04526.txt")
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(string(buf))
+	f.Add("ENTRY       K00001\n///\n")
+	f.Add("  AUTHORS   orphan\n")
+	f.Add(" \t\n \n")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		for _, entry := range parseKeggEntries(text) {
+			parseKeggOrthology(entry)
+		}
+	})
+}
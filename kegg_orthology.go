@@ -2,143 +2,315 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"log"
-	"net/http"
-	"sort"
 	"strings"
 )
 
+// keggOrthologyVersion is bumped whenever parseKeggOrthology extracts more
+// data, so that stored records from earlier versions are fetched again.
+const keggOrthologyVersion = 2
+
+type keggReference struct {
+	Reference string
+	Authors   string
+	Title     string
+	Journal   string
+}
+
 type keggOrthology struct {
 	ID          string `storm:"id"`
+	Symbol      string
+	Name        string
 	Description string
 	Links       []keggLink
+	Brite       []string
+	DBLinks     map[string][]string
+	Genes       map[string][]string
+	References  []keggReference
+	Version     int
+}
+
+// keggMapNumber strips the prefix of a pathway ID so that organism specific
+// pathways (hsa04110), reference maps (map04110) and KO pathways (ko04110)
+// compare equal.
+func keggMapNumber(id string) string {
+	return strings.TrimLeft(id, "abcdefghijklmnopqrstuvwxyz")
 }
 
 func (k keggOrthology) getDescription(id string, domain string) string {
-	if i := sort.Search(len(k.Links), func(i int) bool {
-		return k.Links[i].ID == id && k.Links[i].Domain == domain
-	}); i < len(k.Links) {
-		return k.Links[i].Description
+	for _, link := range k.Links {
+		if link.Domain == domain && keggMapNumber(link.ID) == keggMapNumber(id) {
+			return link.Description
+		}
 	}
 	return ""
 }
 
-func parseKeggOrthology(entry string) (item keggOrthology) {
-	lines := strings.Split(entry, "\n")
+// dbLinkNames maps DBLINKS database names to the names used in the urls file.
+var dbLinkNames = map[string]string{
+	"RN":  "KEGG",
+	"COG": "COG",
+	"GO":  "GO",
+}
+
+func (k keggOrthology) ToGlinks() (list []glinksLink) {
+	if len(k.Symbol) > 0 {
+		list = append(list, createGlinksLink("KO Symbol", k.ID, "", k.Symbol))
+	}
 
-	var links []keggLink
+	if len(k.Name) > 0 {
+		list = append(list, createGlinksLink("KO Name", k.ID, "", k.Name))
+	}
 
-	for i := 0; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
+	for _, link := range k.Links {
+		if link.Domain == "MODULE" || link.Domain == "REACTION" {
+			list = append(list, link.ToGlinks()...)
+		}
+	}
 
-		fields := filterEmpty(strings.Split(line, " "))
+	for _, path := range k.Brite {
+		list = append(list, createGlinksLink("KEGG_BRITE", k.ID, "", path))
+	}
+
+	for db, ids := range k.DBLinks {
+		name, ok := dbLinkNames[db]
+
+		if !ok {
+			name = db
+		}
+
+		host, err := getDBHost(name)
+
+		for _, id := range ids {
+			if db == "GO" {
+				id = "GO:" + id
+			}
+
+			if err != nil {
+				list = append(list, createGlinksLink(db, id, "", id))
+			} else {
+				list = append(list, createGlinksLink(db, id, strings.Replace(host, ":id", id, -1), ""))
+			}
+		}
+	}
+
+	for _, reference := range k.References {
+		if !strings.HasPrefix(reference.Reference, "PMID:") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(reference.Reference, "PMID:"))
 
 		if len(fields) == 0 {
 			continue
 		}
 
-		switch fields[0] {
-		case "ENTRY":
-			item.ID = fields[1]
-
-		case "DEFINITION":
-			item.Description = strings.Join(fields[1:], " ")
-
-		case "PATHWAY":
-			links = append(links, keggLink{
-				ID:          fields[1],
-				Domain:      fields[0],
-				Description: strings.Join(fields[2:], " "),
-			})
-
-			for j := 1; len(lines[i+j]) != len(strings.TrimSpace(lines[i+j])); j++ {
-				line = lines[i+j]
-				fields = filterEmpty(strings.Split(line, " "))
-				links = append(links, keggLink{
-					ID:          fields[0],
-					Domain:      "PATHWAY",
-					Description: strings.Join(fields[1:], " "),
-				})
-			}
+		pmid := fields[0]
+
+		link, _ := getDBHost("PubMed")
+		link = strings.Replace(link, ":id", pmid, -1)
+
+		list = append(list, createGlinksLink("PubMed", pmid, link, reference.Title))
+	}
+
+	return list
+}
+
+func getKeggOrthology(id string) (item keggOrthology, err error) {
+	err = db.Get("KeggOrthology", id, &item)
+	return item, err
+}
+
+// parseBrite returns the path from the root of every hierarchy in the BRITE
+// section down to the entry itself, e.g.
+// "KEGG Orthology (KO) [BR:ko00001] > 09100 Metabolism > ...".
+func parseBrite(id string, section keggSection) (paths []string) {
+	var stack []string
+	var depths []int
+
+	for _, line := range section.Raw {
+		text := strings.TrimSpace(line)
+		depth := len(line) - len(strings.TrimLeft(line, " "))
 
-		case "DISEASE":
-			links = append(links, keggLink{
-				ID:          fields[1],
-				Domain:      fields[0],
-				Description: strings.Join(fields[2:], " "),
-			})
-
-			for j := 1; len(lines[i+j]) != len(strings.TrimSpace(lines[i+j])); j++ {
-				line = lines[i+j]
-				fields = filterEmpty(strings.Split(line, " "))
-				links = append(links, keggLink{
-					ID:          fields[1],
-					Domain:      "DISEASE",
-					Description: strings.Join(fields[2:], " "),
-				})
+		for len(depths) > 0 && depths[len(depths)-1] >= depth {
+			stack = stack[:len(stack)-1]
+			depths = depths[:len(depths)-1]
+		}
+
+		if fields := strings.Fields(text); len(fields) > 0 && fields[0] == id {
+			if len(stack) > 0 {
+				paths = append(paths, strings.Join(stack, " > "))
 			}
+			continue
+		}
+
+		stack = append(stack, text)
+		depths = append(depths, depth)
+	}
+
+	return paths
+}
+
+// parseKeggDBLinks parses lines of the form "DB: ID ID" as in the DBLINKS
+// and GENES sections.
+func parseKeggDBLinks(section keggSection) map[string][]string {
+	ret := make(map[string][]string)
+
+	for _, line := range section.Lines {
+		i := strings.Index(line, ":")
+
+		if i < 0 {
+			continue
 		}
+
+		db := strings.TrimSpace(line[:i])
+		ret[db] = append(ret[db], strings.Fields(line[i+1:])...)
+	}
+
+	return ret
+}
+
+func parseKeggOrthology(entry keggEntry) (item keggOrthology) {
+	item.ID = entry.ID()
+	item.Version = keggOrthologyVersion
+
+	// Older KO entries carry the symbol in NAME and the name in DEFINITION.
+	if _, ok := entry.Section("SYMBOL"); ok {
+		item.Symbol = entry.Text("SYMBOL")
+		item.Name = entry.Text("NAME")
+	} else {
+		item.Symbol = entry.Text("NAME")
+		item.Name = entry.Text("DEFINITION")
 	}
 
-	item.Links = links
+	item.Description = entry.Text("DEFINITION")
+
+	if len(item.Description) == 0 {
+		item.Description = item.Name
+	}
+
+	for _, domain := range []string{"PATHWAY", "MODULE", "DISEASE", "REACTION"} {
+		item.Links = append(item.Links, entry.Links(domain, domain)...)
+	}
+
+	if section, ok := entry.Section("BRITE"); ok {
+		item.Brite = parseBrite(item.ID, section)
+	}
+
+	if section, ok := entry.Section("DBLINKS"); ok {
+		item.DBLinks = parseKeggDBLinks(section)
+	}
+
+	if section, ok := entry.Section("GENES"); ok {
+		item.Genes = parseKeggDBLinks(section)
+	}
+
+	for _, section := range entry.All("REFERENCE") {
+		reference := keggReference{Reference: section.Text()}
+
+		for _, sub := range section.Subsections {
+			switch sub.Name {
+			case "AUTHORS":
+				reference.Authors = sub.Text()
+			case "TITLE":
+				reference.Title = sub.Text()
+			case "JOURNAL":
+				reference.Journal = sub.Text()
+			}
+		}
+
+		item.References = append(item.References, reference)
+	}
 
 	return item
 }
 
-func init() {
-	log.Println("Update KEGG Orthology")
+// keggGetLimit is the maximum number of entries KEGG REST returns per get.
+const keggGetLimit = 10
 
-	var tmp keggOrthology
+// loadKeggOrthology fetches the KOs missing from the store. Startup only fails
+// if no KEGG Orthology has ever been loaded.
+func loadKeggOrthology() {
+	log.Println("Update KEGG Orthology")
 
-	err := db.Get("KeggOrthology", "K22128", &tmp)
+	err := updateKeggOrthology()
 
-	if err == nil && tmp.ID == "K22128" {
+	if err == nil {
 		return
 	}
 
-	if err := updateKeggOrthology(); err != nil {
+	if _, loadErr := loadSourceRelease("KeggOrthology"); loadErr != nil {
 		log.Fatal(err)
 	}
+
+	log.Printf("Failed to update KEGG Orthology: %s", err)
 }
 
-func updateKeggOrthology() error {
-	res, err := http.Get("http://rest.kegg.jp/list/orthology")
+func listKeggOrthology(ctx context.Context) ([]string, error) {
+	res, err := upstream.Get(ctx, keggURL+"/list/orthology")
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer res.Body.Close()
 
+	if err := checkStatus(res); err != nil {
+		return nil, err
+	}
+
 	scanner := bufio.NewScanner(res.Body)
 
 	var ids []string
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		fields := strings.Split(scanner.Text(), "\t")
 
-		ko, _ := splitTwo(line, "\t")
+		if len(fields[0]) > 0 {
+			ids = append(ids, strings.TrimPrefix(fields[0], "ko:"))
+		}
+	}
 
-		_, id := splitTwo(ko, ":")
+	return ids, scanner.Err()
+}
 
-		ids = append(ids, id)
-	}
+// updateKeggOrthology fetches every KO not yet in the store, or stored by an
+// older version of the parser, from KEGG REST.
+func updateKeggOrthology() error {
+	ctx := context.Background()
 
-	result, err := fetchList("http://togows.org/entry/kegg-orthology/", ids, ",", 2000)
+	ids, err := listKeggOrthology(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	list := strings.Split(result, "///")
+	var missing []string
+
+	for _, id := range ids {
+		if item, err := getKeggOrthology(id); err != nil || item.Version < keggOrthologyVersion {
+			missing = append(missing, id)
+		}
+	}
 
-	list = list[:len(list)-1]
+	log.Printf("Fetching %d of %d KOs", len(missing), len(ids))
 
-	for _, item := range list {
-		ko := parseKeggOrthology(item)
+	err = fetchList(ctx, keggURL+"/get/", missing, "+", 2000, keggGetLimit, func(result string) error {
+		for _, entry := range parseKeggEntries(result) {
+			ko := parseKeggOrthology(entry)
 
-		db.Set("KeggOrthology", ko.ID, &ko)
+			if err := db.Set("KeggOrthology", ko.ID, &ko); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return saveSourceRelease("KeggOrthology", "")
 }
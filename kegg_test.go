@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseKeggGeneID(t *testing.T) {
+	tests := []struct {
+		query string
+		gene  string
+		ok    bool
+	}{
+		{"hsa:3630", "hsa:3630", true},
+		{"eco:b0002", "eco:b0002", true},
+		{"KEGG:hsa:3630", "hsa:3630", true},
+		{"T01001:3630", "T01001:3630", true},
+		{"path:map04910", "", false},
+		{"cpd:C00031", "", false},
+		{"drug:D00085", "", false},
+		{"kegg:path:hsa04910", "", false},
+		{"pdb:1ZNI", "", false},
+		{"P01308", "", false},
+		{"RefSeq:NM_000207", "", false},
+	}
+
+	for _, test := range tests {
+		gene, ok := parseKeggGeneID(test.query)
+
+		if gene != test.gene || ok != test.ok {
+			t.Errorf("parseKeggGeneID(%q) = %q, %t, want %q, %t", test.query, gene, ok, test.gene, test.ok)
+		}
+	}
+}
@@ -1,16 +1,47 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"strings"
 	"time"
 )
 
-var domainMap = map[string]string{
-	"path": "PATHWAY",
-	"ds":   "DISEASE",
-	"ko":   "ORTHOLOGY",
-	"br":   "BRITE",
+// domainMap maps the LinkDB database prefixes to keep to their domains. It
+// holds every domain in keggDomains but the optional ones unless restricted
+// through LINKDB_DOMAINS, a comma separated list of prefixes or domain names,
+// e.g. "path,ko,uniprot".
+var domainMap = createDomainMap()
+
+// optionalDomains lists the domains that repeat the dbReference rows of the
+// UniProt entry of a gene. They are only kept if listed in LINKDB_DOMAINS.
+var optionalDomains = map[string]bool{
+	"NCBI-GENEID":    true,
+	"NCBI-PROTEINID": true,
+	"UNIPROT":        true,
+}
+
+func createDomainMap() map[string]string {
+	ret := make(map[string]string)
+
+	var selected []string
+
+	if value := os.Getenv("LINKDB_DOMAINS"); len(value) > 0 {
+		selected = strings.Split(value, ",")
+	}
+
+	for name, domain := range keggDomains {
+		if len(domain.Prefix) == 0 {
+			continue
+		}
+
+		if selected == nil && !optionalDomains[name] || containsString(selected, domain.Prefix) || containsString(selected, strings.ToLower(name)) {
+			ret[domain.Prefix] = name
+		}
+	}
+
+	return ret
 }
 
 type linkDB struct {
@@ -46,19 +77,75 @@ func linkDBLoadCache(id string) (item linkDB, err error) {
 }
 
 func (l linkDB) ToGlinks() (list []glinksLink) {
+	var orthologies []keggOrthology
+
 	for _, link := range l.Links {
+		if link.Domain == "ORTHOLOGY" {
+			if ko, err := getKeggOrthology(link.ID); err == nil {
+				orthologies = append(orthologies, ko)
+			}
+		}
+	}
+
+	links := l.Links
+
+	if collapsePathways {
+		links = l.canonicalPathways()
+	}
+
+	for _, link := range links {
+		// Name pathways and diseases after the entries of the gene's KOs.
+		for _, ko := range orthologies {
+			if len(link.Description) > 0 {
+				break
+			}
+
+			link.Description = ko.getDescription(link.ID, link.Domain)
+		}
+
 		list = append(list, link.ToGlinks()...)
 	}
+
 	return list
 }
 
-func fetchLinkDB(list []string) (ret []linkDB, err error) {
-	result, err := fetchList("http://rest.genome.jp/link/", list, "+", 4000)
+// canonicalPathways returns the links with a single pathway per map number,
+// named after the organism in the gene ID, e.g. hsa04110 for hsa:7157 even if
+// LinkDB only linked map04110.
+func (l linkDB) canonicalPathways() (ret []keggLink) {
+	var organism string
 
-	if err != nil {
-		return nil, err
+	if i := strings.Index(l.ID, ":"); i > 0 {
+		organism = l.ID[:i]
 	}
 
+	seen := make(map[string]bool)
+
+	for _, link := range l.Links {
+		if link.Domain != "PATHWAY" {
+			ret = append(ret, link)
+			continue
+		}
+
+		number := keggMapNumber(link.ID)
+
+		if seen[number] {
+			continue
+		}
+
+		seen[number] = true
+
+		if len(organism) > 0 && !referencePathwayPrefixes[organism] {
+			link.ID = organism + number
+		}
+
+		ret = append(ret, link)
+	}
+
+	return ret
+}
+
+func parseLinkDB(result string) (ret []linkDB) {
 	lines := strings.Split(result, "\n")
 
 	var subject linkDB
@@ -95,12 +182,23 @@ func fetchLinkDB(list []string) (ret []linkDB, err error) {
 		}
 	}
 
-	ret = append(ret, subject)
+	if len(subject.ID) > 0 {
+		ret = append(ret, subject)
+	}
+
+	return ret
+}
+
+func fetchLinkDB(ctx context.Context, list []string) (ret []linkDB, err error) {
+	err = fetchList(ctx, linkDBURL+"/link/", list, "+", 4000, 0, func(result string) error {
+		ret = append(ret, parseLinkDB(result)...)
+		return nil
+	})
 
-	return ret, nil
+	return ret, err
 }
 
-func getLinkDB(ids []string) ([]linkDB, error) {
+func getLinkDB(ctx context.Context, ids []string) ([]linkDB, error) {
 	var cached []linkDB
 	var missed []string
 
@@ -115,12 +213,8 @@ func getLinkDB(ids []string) ([]linkDB, error) {
 		}
 	}
 
-	if len(missed) > 0 {
-		list, err := fetchLinkDB(missed)
-
-		if err != nil {
-			log.Printf("Failed to fetch from LinkDB: %s", err)
-		}
+	if len(missed) > 0 && !offline {
+		list, err := fetchLinkDB(ctx, missed)
 
 		for _, item := range list {
 			if err := item.SaveCache(); err != nil {
@@ -128,6 +222,10 @@ func getLinkDB(ids []string) ([]linkDB, error) {
 			}
 			cached = append(cached, item)
 		}
+
+		if err != nil {
+			return cached, err
+		}
 	}
 
 	return cached, nil
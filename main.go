@@ -13,15 +13,17 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 )
 
-func createMux() (*storm.DB, *echo.Echo) {
-	log.Println("Spinning up G-Links")
-
-	// Setup Storm
-	dbPath := os.Getenv("DB_PATH")
+var commands = map[string]func(args []string) error{
+	"serve":          serve,
+	"build-mappings": buildMappings,
+	"warm":           warm,
+	"export":         exportSnapshot,
+	"import":         importSnapshot,
+	"mock":           mock,
+}
 
-	if len(dbPath) == 0 {
-		dbPath = "."
-	}
+func openDB() *storm.DB {
+	dbPath := getEnv("DB_PATH", ".")
 
 	db, err := storm.Open(fmt.Sprintf("%s/glinks.db", dbPath))
 
@@ -29,7 +31,10 @@ func createMux() (*storm.DB, *echo.Echo) {
 		log.Fatal("Storm DB could not be opened: ", err)
 	}
 
-	// Setup Echo
+	return db
+}
+
+func createMux() *echo.Echo {
 	e := echo.New()
 
 	e.Use(middleware.CORS())
@@ -37,16 +42,28 @@ func createMux() (*storm.DB, *echo.Echo) {
 	e.Use(middleware.Recover())
 	e.Use(middleware.Gzip())
 
-	return db, e
+	return e
 }
 
-func main() {
+func serve(args []string) error {
+	log.Println("Spinning up G-Links")
+
+	db = openDB()
 	defer db.Close()
 
+	mappings = createMappings()
+
 	for _, v := range mappings {
 		defer v.Close()
 	}
 
+	if offline {
+		log.Println("Running in offline mode, upstream sources are disabled")
+	} else {
+		updateGeneOntology()
+		loadKeggOrthology()
+	}
+
 	log.Println("Welcome to G-Links")
 
 	// Setup target and serve
@@ -55,5 +72,24 @@ func main() {
 
 	target := fmt.Sprintf("%s:%s", host, port)
 
-	e.Logger.Debug(e.Start(target))
+	return e.Start(target)
+}
+
+func main() {
+	name := "serve"
+	args := os.Args[1:]
+
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+
+	if !ok {
+		log.Fatalf("Unknown command: %s", name)
+	}
+
+	if err := command(args); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo"
+)
+
+// mockEntry is a UniProt entry served by the mock upstream, kept as raw XML.
+type mockEntry struct {
+	Accession []string `xml:"accession"`
+	Name      []string `xml:"name"`
+	Organism  struct {
+		DbReference struct {
+			ID string `xml:"id,attr"`
+		} `xml:"dbReference"`
+	} `xml:"organism"`
+	Attrs []xml.Attr `xml:",any,attr"`
+	Inner string     `xml:",innerxml"`
+}
+
+// mockUpstream serves the subset of the UniProt, LinkDB, KEGG and
+// Gene Ontology endpoints used by G-Links from a directory of fixture files:
+//
+//	uniprot/*.xml          UniProt XML documents
+//	linkdb/*.tsv           LinkDB link lines, named after the KEGG gene ID
+//	                       with ":" replaced by "_"
+//	kegg/orthology.list    response of the KEGG orthology list
+//	kegg/*.txt             KEGG flat file entries, named after the ID
+//	go.obo                 Gene Ontology
+//
+// A requested ID of the form status:NNN makes the endpoint respond with that
+// status code so error handling can be exercised.
+type mockUpstream struct {
+	Dir     string
+	Entries []mockEntry
+	Index   map[string]int
+
+	mutex sync.Mutex
+	jobs  map[string][]string
+	polls map[string]int
+}
+
+func newMockUpstream(dir string) (*mockUpstream, error) {
+	m := &mockUpstream{
+		Dir:   dir,
+		Index: make(map[string]int),
+		jobs:  make(map[string][]string),
+		polls: make(map[string]int),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "uniprot", "*.xml"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var doc struct {
+			Entry []mockEntry `xml:"entry"`
+		}
+
+		if err := xml.Unmarshal(buf, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		for _, entry := range doc.Entry {
+			for _, id := range append(entry.Accession, entry.Name...) {
+				m.Index[id] = len(m.Entries)
+			}
+
+			m.Entries = append(m.Entries, entry)
+		}
+	}
+
+	return m, nil
+}
+
+// mockStatus returns the status code requested by an ID of the form
+// status:NNN among the given IDs, or zero.
+func mockStatus(ids []string) int {
+	for _, id := range ids {
+		if strings.HasPrefix(id, "status:") {
+			if code, err := strconv.Atoi(strings.TrimPrefix(id, "status:")); err == nil {
+				return code
+			}
+		}
+	}
+	return 0
+}
+
+func (m *mockUpstream) writeEntries(c echo.Context, list []int) error {
+	format := c.QueryParam("format")
+
+	if format == "list" {
+		var lines []string
+
+		for _, i := range list {
+			lines = append(lines, m.Entries[i].Accession[0])
+		}
+
+		return c.String(http.StatusOK, strings.Join(lines, "\n")+"\n")
+	}
+
+	var body []string
+
+	body = append(body, `<?xml version="1.0" encoding="UTF-8"?>`, `<uniprot xmlns="http://uniprot.org/uniprot">`)
+
+	for _, i := range list {
+		body = append(body, "<entry"+m.Entries[i].attributes()+">"+m.Entries[i].Inner+"</entry>")
+	}
+
+	body = append(body, "</uniprot>")
+
+	return c.Blob(http.StatusOK, "application/xml", []byte(strings.Join(body, "\n")))
+}
+
+// attributes returns the attributes of the entry element as written in the
+// fixture file, e.g. ` dataset="Swiss-Prot"`.
+func (m mockEntry) attributes() string {
+	var ret strings.Builder
+
+	for _, attr := range m.Attrs {
+		fmt.Fprintf(&ret, " %s=\"", attr.Name.Local)
+		xml.EscapeText(&ret, []byte(attr.Value))
+		ret.WriteString("\"")
+	}
+
+	return ret.String()
+}
+
+func (m *mockUpstream) lookup(ids []string) (list []int) {
+	for _, id := range ids {
+		stem, _ := splitVersion("UniProtKB-AC", id)
+
+		if i, ok := m.Index[stem]; ok {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+func (m *mockUpstream) entry(c echo.Context) error {
+	id := strings.TrimSuffix(c.Param("file"), ".xml")
+
+	if code := mockStatus([]string{id}); code != 0 {
+		return c.NoContent(code)
+	}
+
+	list := m.lookup([]string{id})
+
+	if len(list) == 0 {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	return m.writeEntries(c, list)
+}
+
+func (m *mockUpstream) stream(c echo.Context) error {
+	var accessions []string
+
+	organism := ""
+
+	for _, term := range strings.Fields(c.QueryParam("query")) {
+		switch {
+		case strings.HasPrefix(term, "accession:"):
+			accessions = append(accessions, strings.TrimPrefix(term, "accession:"))
+		case strings.HasPrefix(term, "organism_id:"):
+			organism = strings.TrimPrefix(term, "organism_id:")
+		}
+	}
+
+	if code := mockStatus(accessions); code != 0 {
+		return c.NoContent(code)
+	}
+
+	var list []int
+
+	if len(organism) > 0 {
+		for i, entry := range m.Entries {
+			if entry.Organism.DbReference.ID == organism {
+				list = append(list, i)
+			}
+		}
+	} else {
+		list = m.lookup(accessions)
+	}
+
+	return m.writeEntries(c, list)
+}
+
+func (m *mockUpstream) runJob(c echo.Context) error {
+	ids := strings.Split(c.FormValue("ids"), ",")
+
+	if code := mockStatus(ids); code != 0 {
+		return c.NoContent(code)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id := fmt.Sprintf("mock%d", len(m.jobs)+1)
+	m.jobs[id] = ids
+
+	return c.JSON(http.StatusOK, uniprotJob{JobID: id})
+}
+
+func (m *mockUpstream) jobStatus(c echo.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id := c.Param("id")
+
+	if _, ok := m.jobs[id]; !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	// Like UniProt, report the job as running before redirecting to the
+	// results, so that clients have to poll.
+	m.polls[id]++
+
+	if m.polls[id] < 2 {
+		return c.JSON(http.StatusOK, uniprotJob{JobStatus: "RUNNING"})
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/idmapping/uniprotkb/results/"+id)
+}
+
+// jobResults serves the results of a job in pages linked through the Link
+// header like the UniProt API.
+func (m *mockUpstream) jobResults(c echo.Context) error {
+	m.mutex.Lock()
+	ids, ok := m.jobs[c.Param("id")]
+	finished := m.polls[c.Param("id")] >= 2
+	m.mutex.Unlock()
+
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	if !finished {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	list := m.lookup(ids)
+
+	size, err := strconv.Atoi(c.QueryParam("size"))
+
+	if err != nil || size <= 0 {
+		size = 25
+	}
+
+	cursor, _ := strconv.Atoi(c.QueryParam("cursor"))
+
+	if cursor > len(list) {
+		cursor = len(list)
+	}
+
+	end := cursor + size
+
+	if end < len(list) {
+		req := c.Request()
+		next := fmt.Sprintf("%s://%s%s?format=%s&size=%d&cursor=%d", c.Scheme(), req.Host, req.URL.Path, c.QueryParam("format"), size, end)
+		c.Response().Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next))
+	} else {
+		end = len(list)
+	}
+
+	return m.writeEntries(c, list[cursor:end])
+}
+
+func (m *mockUpstream) link(c echo.Context) error {
+	ids := strings.Split(c.Param("ids"), "+")
+
+	if code := mockStatus(ids); code != 0 {
+		return c.NoContent(code)
+	}
+
+	var body []byte
+
+	for _, id := range ids {
+		buf, err := ioutil.ReadFile(filepath.Join(m.Dir, "linkdb", strings.Replace(id, ":", "_", -1)+".tsv"))
+
+		if err == nil {
+			body = append(body, buf...)
+		}
+	}
+
+	return c.Blob(http.StatusOK, echo.MIMETextPlain, body)
+}
+
+func (m *mockUpstream) keggGet(c echo.Context) error {
+	ids := strings.Split(c.Param("ids"), "+")
+
+	if code := mockStatus(ids); code != 0 {
+		return c.NoContent(code)
+	}
+
+	var body []byte
+
+	for _, id := range ids {
+		buf, err := ioutil.ReadFile(filepath.Join(m.Dir, "kegg", strings.TrimPrefix(id, "ko:")+".txt"))
+
+		if err == nil {
+			body = append(body, buf...)
+		}
+	}
+
+	return c.Blob(http.StatusOK, echo.MIMETextPlain, body)
+}
+
+func (m *mockUpstream) file(name string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		buf, err := ioutil.ReadFile(filepath.Join(m.Dir, name))
+
+		if err != nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		return c.Blob(http.StatusOK, echo.MIMETextPlain, buf)
+	}
+}
+
+// Echo returns a server for the mock upstream. It can be used directly with
+// httptest.NewServer.
+func (m *mockUpstream) Echo() *echo.Echo {
+	e := echo.New()
+
+	e.GET("/uniprotkb/stream", m.stream)
+	e.GET("/uniprotkb/:file", m.entry)
+	e.POST("/idmapping/run", m.runJob)
+	e.GET("/idmapping/status/:id", m.jobStatus)
+	e.GET("/idmapping/uniprotkb/results/:id", m.jobResults)
+	e.GET("/link/:ids", m.link)
+	e.GET("/list/orthology", m.file("kegg/orthology.list"))
+	e.GET("/get/:ids", m.keggGet)
+	e.GET("/go.obo", m.file("go.obo"))
+
+	return e
+}
+
+func mock(args []string) error {
+	flags := flag.NewFlagSet("mock", flag.ExitOnError)
+
+	dir := flags.String("data", "mock", "directory with the fixture files to serve")
+	addr := flags.String("addr", "localhost:8081", "address to listen on")
+
+	flags.Parse(args)
+
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+
+	m, err := newMockUpstream(*dir)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving %d UniProt entries from %s on %s", len(m.Entries), *dir, *addr)
+	log.Printf("Point G-Links at it with UNIPROT_URL=http://%[1]s LINKDB_URL=http://%[1]s KEGG_URL=http://%[1]s GENE_ONTOLOGY_URL=http://%[1]s/go.obo", *addr)
+
+	return m.Echo().Start(*addr)
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/asdine/storm"
+	bolt "github.com/coreos/bbolt"
+)
+
+// snapshotVersion is the version of the snapshot archive format.
+const snapshotVersion = 1
+
+// snapshotBatch is the number of entries imported per transaction.
+const snapshotBatch = 1000
+
+// snapshotBuckets lists the cache buckets included in snapshots.
+var snapshotBuckets = []string{"UniProt", "LinkDB", "GO", "KeggOrthology"}
+
+// sourceRelease records which release of an upstream source is cached.
+type sourceRelease struct {
+	Version   string    `json:"version,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func saveSourceRelease(source, version string) error {
+	return db.Set("Metadata", source, sourceRelease{Version: version, UpdatedAt: time.Now()})
+}
+
+func loadSourceRelease(source string) (release sourceRelease, err error) {
+	err = db.Get("Metadata", source, &release)
+	return release, err
+}
+
+type snapshotBucket struct {
+	File    string        `json:"file"`
+	Count   int           `json:"count"`
+	Release sourceRelease `json:"release"`
+	Oldest  time.Time     `json:"oldest,omitempty"`
+	Newest  time.Time     `json:"newest,omitempty"`
+}
+
+type snapshotManifest struct {
+	Version   int                       `json:"version"`
+	CreatedAt time.Time                 `json:"createdAt"`
+	Buckets   map[string]snapshotBucket `json:"buckets"`
+}
+
+func writeTarFile(w *tar.Writer, name string, file *os.File) error {
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: time.Now(),
+	}
+
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, file)
+
+	return err
+}
+
+// exportBucket writes every value of the bucket as a line of JSON to file.
+func exportBucket(name string, file *os.File) (bucket snapshotBucket, err error) {
+	bucket.File = name + ".jsonl"
+	bucket.Release, _ = loadSourceRelease(name)
+
+	w := bufio.NewWriter(file)
+
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var item struct{ UpdatedAt time.Time }
+
+			if err := json.Unmarshal(v, &item); err == nil && !item.UpdatedAt.IsZero() {
+				if bucket.Oldest.IsZero() || item.UpdatedAt.Before(bucket.Oldest) {
+					bucket.Oldest = item.UpdatedAt
+				}
+
+				if item.UpdatedAt.After(bucket.Newest) {
+					bucket.Newest = item.UpdatedAt
+				}
+			}
+
+			bucket.Count++
+
+			if _, err := w.Write(v); err != nil {
+				return err
+			}
+
+			return w.WriteByte('\n')
+		})
+	})
+
+	if err != nil {
+		return bucket, err
+	}
+
+	return bucket, w.Flush()
+}
+
+// exportSnapshot dumps the cache buckets to a gzipped tar archive containing
+// a manifest and one JSON lines file per bucket.
+func exportSnapshot(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+
+	out := flags.String("out", fmt.Sprintf("glinks-%s.tar.gz", time.Now().Format("20060102")), "path of the archive to write")
+
+	flags.Parse(args)
+
+	db = openDB()
+	defer db.Close()
+
+	manifest := snapshotManifest{
+		Version:   snapshotVersion,
+		CreatedAt: time.Now(),
+		Buckets:   make(map[string]snapshotBucket),
+	}
+
+	var files []*os.File
+
+	defer func() {
+		for _, file := range files {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	for _, name := range snapshotBuckets {
+		file, err := ioutil.TempFile("", "glinks-"+name)
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file)
+
+		bucket, err := exportBucket(name, file)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Exported %d entries from %s", bucket.Count, name)
+
+		manifest.Buckets[name] = bucket
+	}
+
+	archive, err := os.Create(*out)
+
+	if err != nil {
+		return err
+	}
+
+	defer archive.Close()
+
+	gz := gzip.NewWriter(archive)
+	w := tar.NewWriter(gz)
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := w.WriteHeader(&tar.Header{
+		Name:    "manifest.json",
+		Mode:    0644,
+		Size:    int64(len(body)),
+		ModTime: manifest.CreatedAt,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+
+	for i, name := range snapshotBuckets {
+		if err := writeTarFile(w, manifest.Buckets[name].File, files[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote snapshot to %s", *out)
+
+	return nil
+}
+
+// importEntry merges a single exported entry into the cache. Entries with a
+// timestamp replace cached ones only if they are newer. Entries without one
+// replace cached ones only if the snapshot holds a newer release.
+func importEntry(tx storm.Node, name string, line []byte, newer bool) (bool, error) {
+	switch name {
+	case "UniProt":
+		var item, cached uniprot
+
+		if err := json.Unmarshal(line, &item); err != nil {
+			return false, err
+		}
+
+		// Entries exported by an older version lack data and would only be
+		// fetched again.
+		if item.Version < uniprotVersion {
+			return false, nil
+		}
+
+		if err := tx.Get(name, item.ID, &cached); err == nil && cached.Version >= uniprotVersion && !item.UpdatedAt.After(cached.UpdatedAt) {
+			return false, nil
+		}
+
+		for _, accession := range item.Accession {
+			if err := tx.Set("UniProtMapping", accession, item.ID); err != nil {
+				return false, err
+			}
+		}
+
+		return true, tx.Set(name, item.ID, &item)
+
+	case "LinkDB":
+		var item, cached linkDB
+
+		if err := json.Unmarshal(line, &item); err != nil {
+			return false, err
+		}
+
+		if err := tx.Get(name, item.ID, &cached); err == nil && !item.UpdatedAt.After(cached.UpdatedAt) {
+			return false, nil
+		}
+
+		return true, tx.Set(name, item.ID, &item)
+
+	case "GO":
+		var item, cached geneOntology
+
+		if err := json.Unmarshal(line, &item); err != nil {
+			return false, err
+		}
+
+		if err := tx.Get(name, item.ID, &cached); err == nil && !newer {
+			return false, nil
+		}
+
+		return true, tx.Set(name, item.ID, &item)
+
+	case "KeggOrthology":
+		var item, cached keggOrthology
+
+		if err := json.Unmarshal(line, &item); err != nil {
+			return false, err
+		}
+
+		if err := tx.Get(name, item.ID, &cached); err == nil && !newer {
+			return false, nil
+		}
+
+		return true, tx.Set(name, item.ID, &item)
+	}
+
+	return false, fmt.Errorf("unknown snapshot bucket: %s", name)
+}
+
+// importSnapshot loads an archive written by exportSnapshot into the cache.
+func importSnapshot(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("import requires the path of a snapshot archive")
+	}
+
+	archive, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	defer archive.Close()
+
+	gz, err := gzip.NewReader(archive)
+
+	if err != nil {
+		return err
+	}
+
+	defer gz.Close()
+
+	db = openDB()
+	defer db.Close()
+
+	r := tar.NewReader(gz)
+
+	var manifest *snapshotManifest
+
+	for {
+		header, err := r.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Name == "manifest.json" {
+			manifest = new(snapshotManifest)
+
+			if err := json.NewDecoder(r).Decode(manifest); err != nil {
+				return err
+			}
+
+			if manifest.Version > snapshotVersion {
+				return fmt.Errorf("snapshot version %d is not supported", manifest.Version)
+			}
+
+			continue
+		}
+
+		if manifest == nil {
+			return fmt.Errorf("snapshot manifest must precede the data")
+		}
+
+		name := ""
+
+		for k, bucket := range manifest.Buckets {
+			if bucket.File == header.Name {
+				name = k
+			}
+		}
+
+		if len(name) == 0 {
+			log.Printf("Skipping unknown file %s", header.Name)
+			continue
+		}
+
+		release := manifest.Buckets[name].Release
+		local, err := loadSourceRelease(name)
+		newer := err != nil || release.UpdatedAt.After(local.UpdatedAt)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+		read, merged := 0, 0
+
+		tx, err := db.Begin(true)
+
+		if err != nil {
+			return err
+		}
+
+		for scanner.Scan() {
+			ok, err := importEntry(tx, name, scanner.Bytes(), newer)
+
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			read++
+
+			if ok {
+				merged++
+			}
+
+			if read%snapshotBatch == 0 {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+
+				if tx, err = db.Begin(true); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		if newer && !release.UpdatedAt.IsZero() {
+			if err := db.Set("Metadata", name, release); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Imported %d of %d entries into %s", merged, read, name)
+	}
+
+	if manifest == nil {
+		return fmt.Errorf("snapshot has no manifest")
+	}
+
+	return nil
+}
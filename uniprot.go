@@ -1,13 +1,15 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -174,7 +176,41 @@ type geneNameType struct {
 }
 
 type geneType struct {
-	Name []geneNameType `xml:"name"`
+	Origin string
+	Name   []geneNameType `xml:"name"`
+}
+
+// geneNameTypes maps the UniProt gene name types to the suffixes of their
+// G-Links database names.
+var geneNameTypes = map[string]string{
+	"primary":       "Primary",
+	"synonym":       "Synonym",
+	"ordered locus": "Ordered Locus",
+	"ORF":           "ORF",
+}
+
+// Primary returns the primary gene symbol, or an empty string if there is none.
+func (g geneType) Primary() string {
+	for _, name := range g.Name {
+		if name.Type == "primary" {
+			return name.Value
+		}
+	}
+	return ""
+}
+
+func (g geneType) ToGlinks() (list []glinksLink) {
+	for _, name := range g.Name {
+		suffix, ok := geneNameTypes[name.Type]
+
+		if !ok {
+			suffix = name.Type
+		}
+
+		list = append(list, createGlinksLink(fmt.Sprintf("Gene Name (%s)", suffix), g.Origin, "", name.Value))
+	}
+
+	return list
 }
 
 type organismNameType struct {
@@ -190,6 +226,33 @@ type organismType struct {
 	} `xml:"lineage"`
 }
 
+// UnmarshalXML keeps the scientific name of the organism, which would
+// otherwise be overwritten by the common and synonym names following it.
+func (o *organismType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		Name        []organismNameType `xml:"name"`
+		DbReference dbReferenceType    `xml:"dbReference"`
+		Lineage     struct {
+			Taxon []string `xml:"taxon"`
+		} `xml:"lineage"`
+	}
+
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	for _, name := range raw.Name {
+		if name.Type == "scientific" || len(o.Name.Value) == 0 {
+			o.Name = name
+		}
+	}
+
+	o.DbReference = raw.DbReference
+	o.Lineage.Taxon = raw.Lineage.Taxon
+
+	return nil
+}
+
 func (o organismType) ToGlinks() []glinksLink {
 	uniprotLink, _ := getDBHost("UniProtTaxonomy")
 	uniprotLink = strings.Replace(uniprotLink, ":id", o.DbReference.ID, -1)
@@ -217,6 +280,28 @@ func (g geneLocationType) ToGlinks() []glinksLink {
 	return []glinksLink{createGlinksLink("Gene Location", g.Name, "", g.Type)}
 }
 
+type sequenceType struct {
+	Origin   string
+	Length   int    `xml:"length,attr"`
+	Mass     int    `xml:"mass,attr"`
+	Checksum string `xml:"checksum,attr"`
+	Modified string `xml:"modified,attr"`
+	Version  int    `xml:"version,attr"`
+	Value    string `xml:",chardata"`
+}
+
+func (s sequenceType) ToGlinks() []glinksLink {
+	if s.Length == 0 {
+		return nil
+	}
+
+	return []glinksLink{
+		createGlinksLink("Sequence Length", s.Origin, "", fmt.Sprintf("%d aa", s.Length)),
+		createGlinksLink("Sequence Mass", s.Origin, "", fmt.Sprintf("%d Da", s.Mass)),
+		createGlinksLink("Sequence Checksum", s.Origin, "", fmt.Sprintf("%s (CRC64, version %d)", s.Checksum, s.Version)),
+	}
+}
+
 type citationType struct {
 	DbReference []dbReferenceType `xml:"dbReference"`
 }
@@ -280,8 +365,14 @@ func (c commentType) ToGlinks() []glinksLink {
 	return []glinksLink{item}
 }
 
+// uniprotVersion is bumped whenever more of the UniProt XML is kept in the
+// cache, so that entries cached by earlier versions are fetched again.
+// Version 1 keeps the dataset, sequence, features and evidences.
+const uniprotVersion = 1
+
 type uniprot struct {
 	ID           string            `storm:"id"`
+	Dataset      string            `xml:"dataset,attr"`
 	Accession    []string          `xml:"accession"`
 	Name         []string          `xml:"name"`
 	Protein      proteinType       `xml:"protein"`
@@ -291,6 +382,10 @@ type uniprot struct {
 	Reference    []referenceType   `xml:"reference"`
 	Comment      []commentType     `xml:"comment"`
 	DbReference  []dbReferenceType `xml:"dbReference"`
+	Feature      []featureType     `xml:"feature"`
+	Evidence     []evidenceType    `xml:"evidence"`
+	Sequence     sequenceType      `xml:"sequence"`
+	Version      int
 	UpdatedAt    time.Time
 }
 
@@ -299,6 +394,7 @@ func (u uniprot) SaveCache() error {
 		db.Set("UniProtMapping", accession, u.ID)
 	}
 	u.UpdatedAt = time.Now()
+	u.Version = uniprotVersion
 	return db.Set("UniProt", u.ID, &u)
 }
 
@@ -315,6 +411,10 @@ func uniprotLoadCache(id string) (item uniprot, err error) {
 		}
 	}
 
+	if item.Version < uniprotVersion {
+		return item, errCacheOutdated
+	}
+
 	if !validTimestamp(item.UpdatedAt) {
 		return item, errTimestampInvalid
 	}
@@ -328,9 +428,15 @@ func (u *uniprot) ToGlinks() glinks {
 	u.Protein.AddOrigin(u.ID)
 	compatible = append(compatible, u.Protein)
 
+	u.Gene.Origin = u.ID
+	compatible = append(compatible, u.Gene)
+
 	compatible = append(compatible, u.Organism)
 	compatible = append(compatible, u.GeneLocation)
 
+	u.Sequence.Origin = u.ID
+	compatible = append(compatible, u.Sequence)
+
 	for _, comment := range u.Comment {
 		comment.Origin = u.ID
 		compatible = append(compatible, comment)
@@ -341,6 +447,12 @@ func (u *uniprot) ToGlinks() glinks {
 		compatible = append(compatible, dbReference)
 	}
 
+	for _, feature := range u.Feature {
+		feature.Origin = u.ID
+		feature.resolveEvidence(u.Evidence)
+		compatible = append(compatible, feature)
+	}
+
 	links := make([]glinksLink, 0)
 
 	for _, accession := range u.Accession {
@@ -362,20 +474,94 @@ func (u *uniprot) ToGlinks() glinks {
 
 	return glinks{
 		ID:    u.ID,
+		Label: u.Gene.Primary(),
 		Links: links,
 	}
 }
 
+// fastaLineWidth is the number of residues per line in FASTA output.
+const fastaLineWidth = 60
+
+// FASTA returns the sequence of the entry in FASTA format with a UniProt style
+// header, e.g. ">sp|P01308|INS_HUMAN Insulin OS=Homo sapiens OX=9606 GN=INS SV=1".
+func (u uniprot) FASTA() string {
+	sequence := strings.Join(strings.Fields(u.Sequence.Value), "")
+
+	if len(sequence) == 0 || len(u.Accession) == 0 {
+		return ""
+	}
+
+	db := "tr"
+
+	if u.Dataset == "Swiss-Prot" {
+		db = "sp"
+	}
+
+	var name string
+
+	if len(u.Name) > 0 {
+		name = u.Name[0]
+	}
+
+	header := fmt.Sprintf(">%s|%s|%s", db, u.Accession[0], name)
+
+	if len(u.Protein.RecommendedName.FullName) > 0 {
+		header += " " + u.Protein.RecommendedName.FullName
+	} else if len(u.Protein.SubmittedName) > 0 {
+		header += " " + u.Protein.SubmittedName[0].FullName
+	}
+
+	if len(u.Organism.Name.Value) > 0 {
+		header += fmt.Sprintf(" OS=%s OX=%s", u.Organism.Name.Value, u.Organism.DbReference.ID)
+	}
+
+	if gene := u.Gene.Primary(); len(gene) > 0 {
+		header += " GN=" + gene
+	}
+
+	header += fmt.Sprintf(" SV=%d", u.Sequence.Version)
+
+	lines := []string{header}
+
+	for i := 0; i < len(sequence); i += fastaLineWidth {
+		end := i + fastaLineWidth
+
+		if end > len(sequence) {
+			end = len(sequence)
+		}
+
+		lines = append(lines, sequence[i:end])
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
 type uniprotBase struct {
 	Entry []uniprot `xml:"entry"`
 }
 
-func fetchUniprot(ids []string) ([]uniprot, error) {
-	var res *http.Response
-	var err error
+// uniprotAccession matches UniProtKB accessions as documented at
+// https://www.uniprot.org/help/accession_numbers
+var uniprotAccession = regexp.MustCompile(`^([OPQ][0-9][A-Z0-9]{3}[0-9]|[A-NR-Z][0-9]([A-Z][A-Z0-9]{2}[0-9]){1,2})(-[0-9]+)?$`)
+
+// uniprotStreamChunk is the number of accessions queried per stream request.
+const uniprotStreamChunk = 100
+
+// nextPageLink matches the URL of the next page in a Link header.
+var nextPageLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// uniprotJobTimeout bounds the time spent waiting for an ID mapping job to
+// finish.
+var uniprotJobTimeout = parseDurationEnv("UNIPROT_JOB_TIMEOUT", 10*time.Minute)
 
-	if len(ids) == 1 {
-		res, err = http.Get(fmt.Sprintf("http://www.uniprot.org/uniprot/%s.xml", ids[0]))
+type uniprotJob struct {
+	JobID     string `json:"jobId"`
+	JobStatus string `json:"jobStatus"`
+}
+
+func fetchUniprot(ctx context.Context, ids []string) ([]uniprot, error) {
+	if len(ids) == 1 && uniprotAccession.MatchString(ids[0]) {
+		res, err := upstream.Get(ctx, fmt.Sprintf("%s/uniprotkb/%s.xml", uniprotURL, ids[0]))
 
 		if err != nil {
 			return nil, err
@@ -383,103 +569,245 @@ func fetchUniprot(ids []string) ([]uniprot, error) {
 
 		defer res.Body.Close()
 
+		// An unknown accession is not an error, the entry just does not exist.
+		if res.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+
 		return processUniprotResponse(res)
 	}
 
-	// Create multipart-form
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-
-	fw, err := w.CreateFormFile("file", "list.txt")
+	var accessions []string
+	var names []string
 
-	if err != nil {
-		return nil, err
+	for _, id := range ids {
+		if uniprotAccession.MatchString(id) {
+			accessions = append(accessions, id)
+		} else {
+			names = append(names, id)
+		}
 	}
 
-	for _, query := range ids {
-		if _, err = fw.Write([]byte(query + "\n")); err != nil {
-			return nil, err
+	var ret []uniprot
+
+	for i := 0; i < len(accessions); i += uniprotStreamChunk {
+		j := i + uniprotStreamChunk
+
+		if j > len(accessions) {
+			j = len(accessions)
+		}
+
+		list, err := streamUniprot(ctx, accessions[i:j])
+
+		if err != nil {
+			return ret, err
 		}
+
+		ret = append(ret, list...)
 	}
 
-	if err = w.WriteField("format", "xml"); err != nil {
-		return nil, err
+	if len(names) > 0 {
+		list, err := mapUniprot(ctx, names)
+
+		if err != nil {
+			return ret, err
+		}
+
+		ret = append(ret, list...)
 	}
 
-	if err = w.WriteField("from", "ACC+ID"); err != nil {
-		return nil, err
+	return ret, nil
+}
+
+// streamUniprot retrieves the entries of the given accessions in a single
+// request to the UniProtKB stream endpoint.
+func streamUniprot(ctx context.Context, accessions []string) ([]uniprot, error) {
+	terms := make([]string, len(accessions))
+
+	for i, accession := range accessions {
+		terms[i] = "accession:" + accession
 	}
 
-	if err = w.WriteField("to", "ACC"); err != nil {
+	params := url.Values{}
+	params.Set("query", strings.Join(terms, " OR "))
+	params.Set("format", "xml")
+
+	res, err := upstream.Get(ctx, fmt.Sprintf("%s/uniprotkb/stream?%s", uniprotURL, params.Encode()))
+
+	if err != nil {
 		return nil, err
 	}
 
-	w.Close()
+	defer res.Body.Close()
+
+	return processUniprotResponse(res)
+}
 
-	// Create and call request
-	req, err := http.NewRequest("POST", "http://www.uniprot.org/uploadlists/", &b)
+// mapUniprot resolves IDs that are not accessions (e.g. entry names) through
+// an ID mapping job and retrieves the resulting entries page by page.
+func mapUniprot(ctx context.Context, ids []string) ([]uniprot, error) {
+	form := url.Values{}
+	form.Set("ids", strings.Join(ids, ","))
+	form.Set("from", "UniProtKB_AC-ID")
+	form.Set("to", "UniProtKB")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", uniprotURL+"/idmapping/run", strings.NewReader(form.Encode()))
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	client := &http.Client{Timeout: time.Duration(120) * time.Second}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err = client.Do(req)
+	job, err := requestUniprotJob(req)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	// The run response carries no status, so the job counts as new until
+	// polling the status says otherwise.
+	job.JobStatus = "NEW"
 
-	return processUniprotResponse(res)
-}
+	deadline := time.Now().Add(uniprotJobTimeout)
 
-func processUniprotResponse(res *http.Response) ([]uniprot, error) {
-	code := res.StatusCode
+	for delay := 500 * time.Millisecond; ; delay *= 2 {
+		switch job.JobStatus {
+		case "FINISHED":
+			return fetchUniprotPages(ctx, fmt.Sprintf("%s/idmapping/uniprotkb/results/%s?format=xml&size=500", uniprotURL, job.JobID))
+		case "ERROR", "FAILED":
+			return nil, fmt.Errorf("uniprot id mapping job %s: %s", job.JobID, job.JobStatus)
+		case "NEW", "RUNNING":
+		default:
+			return nil, fmt.Errorf("uniprot id mapping job %s: %w: %q", job.JobID, errUnexpectedStatus, job.JobStatus)
+		}
 
-	if 200 <= code && code <= 299 {
-		var item uniprotBase
+		if delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
 
-		buf, err := ioutil.ReadAll(res.Body)
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("uniprot id mapping job %s: %w", job.JobID, errJobTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		req, err = http.NewRequestWithContext(withoutRedirects(ctx), "GET", fmt.Sprintf("%s/idmapping/status/%s", uniprotURL, job.JobID), nil)
 
 		if err != nil {
 			return nil, err
 		}
 
-		err = xml.Unmarshal(buf, &item)
+		status, err := requestUniprotJob(req)
 
 		if err != nil {
 			return nil, err
 		}
 
-		for i, entry := range item.Entry {
-			item.Entry[i].ID = entry.Accession[0]
+		status.JobID = job.JobID
+		job = status
+	}
+}
+
+func requestUniprotJob(req *http.Request) (job uniprotJob, err error) {
+	req.Header.Set("Accept", "application/json")
+
+	res, err := upstream.Do(req)
+
+	if err != nil {
+		return job, err
+	}
+
+	defer res.Body.Close()
+
+	// The status endpoint redirects to the results once the job finished.
+	if 300 <= res.StatusCode && res.StatusCode <= 399 {
+		location, err := res.Location()
+
+		if err != nil {
+			return job, err
+		}
+
+		if !strings.Contains(location.Path, "/results/") {
+			return job, fmt.Errorf("uniprot id mapping job redirected to %s", location)
 		}
 
+		job.JobStatus = "FINISHED"
+
+		return job, nil
+	}
+
+	if err := checkStatus(res); err != nil {
+		return job, err
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&job)
+
+	return job, err
+}
+
+// fetchUniprotPages retrieves a paginated result, following the Link headers
+// until the last page.
+func fetchUniprotPages(ctx context.Context, next string) ([]uniprot, error) {
+	var ret []uniprot
+
+	for len(next) > 0 {
+		res, err := upstream.Get(ctx, next)
+
 		if err != nil {
-			return nil, err
+			return ret, err
+		}
+
+		list, err := processUniprotResponse(res)
+
+		res.Body.Close()
+
+		if err != nil {
+			return ret, err
 		}
 
-		return item.Entry, nil
+		ret = append(ret, list...)
+
+		next = ""
+
+		if match := nextPageLink.FindStringSubmatch(res.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
 	}
 
-	if 400 <= code && code <= 499 {
+	return ret, nil
+}
+
+func processUniprotResponse(res *http.Response) ([]uniprot, error) {
+	if err := checkStatus(res); err != nil {
 		log.Println(res.Status)
-		return nil, errHTTPGetClientErr
+		return nil, err
+	}
+
+	var item uniprotBase
+
+	buf, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if 500 <= code && code <= 599 {
-		return nil, errHTTPGetServerErr
+	if err = xml.Unmarshal(buf, &item); err != nil {
+		return nil, err
 	}
 
-	return nil, errHTTPGetUnknownErr
+	for i, entry := range item.Entry {
+		item.Entry[i].ID = entry.Accession[0]
+	}
+
+	return item.Entry, nil
 }
 
-func getUniprot(ids []string) ([]uniprot, error) {
+func getUniprot(ctx context.Context, ids []string) ([]uniprot, error) {
 	var cached []uniprot
 	var missed []string
 
@@ -494,12 +822,8 @@ func getUniprot(ids []string) ([]uniprot, error) {
 		}
 	}
 
-	if len(missed) > 0 {
-		list, err := fetchUniprot(missed)
-
-		if err != nil {
-			log.Printf("Failed to fetch from Uniprot: %s", err)
-		}
+	if len(missed) > 0 && !offline {
+		list, err := fetchUniprot(ctx, missed)
 
 		for _, item := range list {
 			if err := item.SaveCache(); err != nil {
@@ -508,6 +832,10 @@ func getUniprot(ids []string) ([]uniprot, error) {
 
 			cached = append(cached, item)
 		}
+
+		if err != nil {
+			return cached, err
+		}
 	}
 
 	return cached, nil
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type positionType struct {
+	Position int    `xml:"position,attr"`
+	Status   string `xml:"status,attr"`
+}
+
+type locationType struct {
+	Begin    positionType `xml:"begin"`
+	End      positionType `xml:"end"`
+	Position positionType `xml:"position"`
+}
+
+// Range returns the first and last residue of the location, which are equal
+// for single positions. Unknown positions are 0.
+func (l locationType) Range() (int, int) {
+	if l.Position.Position > 0 {
+		return l.Position.Position, l.Position.Position
+	}
+
+	return l.Begin.Position, l.End.Position
+}
+
+type evidenceType struct {
+	Type   string `xml:"type,attr"`
+	Key    string `xml:"key,attr"`
+	Source struct {
+		DbReference []dbReferenceType `xml:"dbReference"`
+	} `xml:"source"`
+}
+
+// String returns the ECO code of the evidence followed by its sources, e.g.
+// "ECO:0000269|PubMed:2153455".
+func (e evidenceType) String() string {
+	ret := e.Type
+
+	for _, dbReference := range e.Source.DbReference {
+		ret += fmt.Sprintf("|%s:%s", dbReference.Type, dbReference.ID)
+	}
+
+	return ret
+}
+
+type featureType struct {
+	Origin      string
+	Type        string       `xml:"type,attr"`
+	ID          string       `xml:"id,attr"`
+	Description string       `xml:"description,attr"`
+	Evidence    string       `xml:"evidence,attr"`
+	Original    string       `xml:"original"`
+	Variation   []string     `xml:"variation"`
+	Location    locationType `xml:"location"`
+	Evidences   []string     `xml:"-"`
+}
+
+// resolveEvidence replaces the evidence keys of the feature by the evidences
+// of the entry they refer to.
+func (f *featureType) resolveEvidence(evidences []evidenceType) {
+	f.Evidences = nil
+
+	for _, key := range strings.Fields(f.Evidence) {
+		for _, evidence := range evidences {
+			if evidence.Key == key {
+				f.Evidences = append(f.Evidences, evidence.String())
+			}
+		}
+	}
+}
+
+// change describes the sequence change of a variant or conflict, e.g. "R -> C".
+func (f featureType) change() string {
+	if len(f.Variation) == 0 {
+		return fmt.Sprintf("%s -> Missing", f.Original)
+	}
+
+	return fmt.Sprintf("%s -> %s", f.Original, strings.Join(f.Variation, ", "))
+}
+
+// Text describes the feature in a single line, e.g.
+// "25-54 Insulin B chain" or "42 R -> C (in a diabetes variant)".
+func (f featureType) Text() string {
+	start, end := f.Location.Range()
+
+	parts := []string{fmt.Sprintf("%d-%d", start, end)}
+
+	if start == end {
+		parts[0] = fmt.Sprintf("%d", start)
+	}
+
+	if len(f.Original) > 0 || len(f.Variation) > 0 {
+		parts = append(parts, f.change())
+	}
+
+	if len(f.Description) > 0 {
+		parts = append(parts, f.Description)
+	}
+
+	if len(f.Evidences) > 0 {
+		parts = append(parts, fmt.Sprintf("[%s]", strings.Join(f.Evidences, ", ")))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (f featureType) ToGlinks() []glinksLink {
+	id := f.ID
+
+	if len(id) == 0 {
+		id = f.Origin
+	}
+
+	item := createGlinksLink(fmt.Sprintf("Feature (%s)", f.Type), id, "", f.Text())
+	item.Start, item.End = f.Location.Range()
+	item.Evidence = f.Evidences
+
+	return []glinksLink{item}
+}
+
+// gffEscape percent-encodes the characters with a special meaning in GFF3
+// attribute values.
+func gffEscape(s string) string {
+	return strings.NewReplacer(
+		"%", "%25",
+		";", "%3B",
+		"=", "%3D",
+		"&", "%26",
+		",", "%2C",
+		"\t", "%09",
+		"\n", "%0A",
+	).Replace(s)
+}
+
+// GFF3 returns the feature as a GFF3 line on seqid, or an empty string if
+// its location is unknown.
+func (f featureType) GFF3(seqid string) string {
+	start, end := f.Location.Range()
+
+	if start == 0 || end == 0 || len(f.Type) == 0 {
+		return ""
+	}
+
+	var attributes []string
+
+	if len(f.ID) > 0 {
+		attributes = append(attributes, "ID="+gffEscape(f.ID))
+	}
+
+	var notes []string
+
+	if len(f.Original) > 0 || len(f.Variation) > 0 {
+		notes = append(notes, gffEscape(f.change()))
+	}
+
+	if len(f.Description) > 0 {
+		notes = append(notes, gffEscape(f.Description))
+	}
+
+	if len(notes) > 0 {
+		attributes = append(attributes, "Note="+strings.Join(notes, ","))
+	}
+
+	if len(f.Evidences) > 0 {
+		var evidences []string
+
+		for _, evidence := range f.Evidences {
+			evidences = append(evidences, gffEscape(evidence))
+		}
+
+		attributes = append(attributes, "evidence="+strings.Join(evidences, ","))
+	}
+
+	column := "."
+
+	if len(attributes) > 0 {
+		column = strings.Join(attributes, ";")
+	}
+
+	return strings.Join([]string{
+		seqid,
+		"UniProtKB",
+		strings.ToUpper(f.Type[:1]) + f.Type[1:],
+		fmt.Sprint(start),
+		fmt.Sprint(end),
+		".",
+		".",
+		".",
+		column,
+	}, "\t")
+}
+
+// GFF3 returns the features of the entry as a GFF3 section without the
+// version pragma.
+func (u uniprot) GFF3() string {
+	if len(u.Accession) == 0 {
+		return ""
+	}
+
+	seqid := u.Accession[0]
+
+	var lines []string
+
+	for _, feature := range u.Feature {
+		feature.resolveEvidence(u.Evidence)
+
+		if line := feature.GFF3(seqid); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
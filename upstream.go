@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeouts holds the per-host timeouts used unless overridden through
+// UPSTREAM_TIMEOUTS. A zero timeout disables the timeout for that host.
+var defaultTimeouts = map[string]time.Duration{
+	"rest.uniprot.org": 120 * time.Second,
+	"ftp.uniprot.org":  0,
+}
+
+// defaultRateLimits holds the per-host limits in requests per second used
+// unless overridden through UPSTREAM_RATE_LIMITS.
+var defaultRateLimits = map[string]float64{
+	"rest.kegg.jp":     3,
+	"rest.genome.jp":   3,
+	"rest.uniprot.org": 5,
+}
+
+// offline disables every upstream request so that requests are served from
+// the cache alone.
+var offline, _ = strconv.ParseBool(os.Getenv("OFFLINE"))
+
+// Base URLs of the upstream services, configurable to point at local mirrors.
+var (
+	uniprotURL      = strings.TrimSuffix(getEnv("UNIPROT_URL", "https://rest.uniprot.org"), "/")
+	linkDBURL       = strings.TrimSuffix(getEnv("LINKDB_URL", "http://rest.genome.jp"), "/")
+	keggURL         = strings.TrimSuffix(getEnv("KEGG_URL", "http://rest.kegg.jp"), "/")
+	geneOntologyURL = getEnv("GENE_ONTOLOGY_URL", "http://purl.obolibrary.org/obo/go.obo")
+)
+
+const defaultUserAgent = "G-Links/2.0 (+http://link.g-language.org/; kotone@sfc.keio.ac.jp)"
+
+// tokenBucket limits the rate of requests to a single host.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Wait blocks until a token is available and takes it, or until the context
+// is done.
+func (t *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		t.mutex.Lock()
+
+		now := time.Now()
+
+		t.tokens += now.Sub(t.last).Seconds() * t.rate
+		t.last = now
+
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+
+		if t.tokens >= 1 {
+			t.tokens--
+			t.mutex.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+
+		t.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// releasingBody gives back a concurrency slot once the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// upstreamClient performs every request made to upstream services. Requests
+// are rate limited per host and bounded in concurrency across all hosts.
+// Failed requests are retried with exponential backoff and jitter.
+type upstreamClient struct {
+	Timeout    time.Duration
+	Timeouts   map[string]time.Duration
+	RateLimits map[string]float64
+	Retries    int
+	MinDelay   time.Duration
+	MaxDelay   time.Duration
+	UserAgent  string
+	Transport  http.RoundTripper
+
+	mutex   sync.Mutex
+	clients map[string]*http.Client
+	buckets map[string]*tokenBucket
+	slots   chan struct{}
+}
+
+var upstream = newUpstreamClient()
+
+func parseDurationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+
+	if len(value) == 0 {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		log.Printf("Invalid duration for %s: %s", key, err)
+		return fallback
+	}
+
+	return duration
+}
+
+// parseHostEnv parses a list of the form host=value,host=value from the
+// given environment variable.
+func parseHostEnv(key string) map[string]string {
+	ret := make(map[string]string)
+
+	for _, pair := range filterEmpty(strings.Split(os.Getenv(key), ",")) {
+		fields := strings.SplitN(pair, "=", 2)
+
+		if len(fields) != 2 {
+			log.Printf("Invalid entry in %s: %s", key, pair)
+			continue
+		}
+
+		ret[fields[0]] = fields[1]
+	}
+
+	return ret
+}
+
+func newUpstreamClient() *upstreamClient {
+	u := &upstreamClient{
+		Timeout:    parseDurationEnv("UPSTREAM_TIMEOUT", 60*time.Second),
+		Timeouts:   make(map[string]time.Duration),
+		RateLimits: make(map[string]float64),
+		Retries:    3,
+		MinDelay:   parseDurationEnv("UPSTREAM_RETRY_DELAY", time.Second),
+		MaxDelay:   parseDurationEnv("UPSTREAM_RETRY_MAX_DELAY", 30*time.Second),
+		UserAgent:  os.Getenv("UPSTREAM_USER_AGENT"),
+		clients:    make(map[string]*http.Client),
+		buckets:    make(map[string]*tokenBucket),
+	}
+
+	for host, timeout := range defaultTimeouts {
+		u.Timeouts[host] = timeout
+	}
+
+	for host, value := range parseHostEnv("UPSTREAM_TIMEOUTS") {
+		timeout, err := time.ParseDuration(value)
+
+		if err != nil {
+			log.Printf("Invalid upstream timeout for %s: %s", host, err)
+			continue
+		}
+
+		u.Timeouts[host] = timeout
+	}
+
+	for host, rate := range defaultRateLimits {
+		u.RateLimits[host] = rate
+	}
+
+	for host, value := range parseHostEnv("UPSTREAM_RATE_LIMITS") {
+		rate, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			log.Printf("Invalid upstream rate limit for %s: %s", host, err)
+			continue
+		}
+
+		u.RateLimits[host] = rate
+	}
+
+	if retries, err := strconv.Atoi(os.Getenv("UPSTREAM_RETRIES")); err == nil {
+		u.Retries = retries
+	}
+
+	concurrency, err := strconv.Atoi(os.Getenv("UPSTREAM_MAX_CONCURRENCY"))
+
+	if err != nil || concurrency <= 0 {
+		concurrency = 8
+	}
+
+	u.slots = make(chan struct{}, concurrency)
+
+	if len(u.UserAgent) == 0 {
+		u.UserAgent = defaultUserAgent
+	}
+
+	// UPSTREAM_FIXTURES records responses to, or replays them from, a directory
+	switch mode := os.Getenv("UPSTREAM_FIXTURES"); mode {
+	case "":
+	case "record", "replay":
+		u.Transport = newFixtureTransport(getEnv("FIXTURES_PATH", "fixtures"), mode == "record")
+	default:
+		log.Printf("Invalid UPSTREAM_FIXTURES mode: %s", mode)
+	}
+
+	return u
+}
+
+// SetTransport replaces the transport of every client, e.g. to replay
+// fixtures in tests.
+func (u *upstreamClient) SetTransport(transport http.RoundTripper) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.Transport = transport
+	u.clients = make(map[string]*http.Client)
+}
+
+func (u *upstreamClient) client(host string) *http.Client {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if client, ok := u.clients[host]; ok {
+		return client
+	}
+
+	timeout, ok := u.Timeouts[host]
+
+	if !ok {
+		timeout = u.Timeout
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: u.Transport, CheckRedirect: checkRedirect}
+	u.clients[host] = client
+
+	return client
+}
+
+type noRedirectKey struct{}
+
+// withoutRedirects returns a context whose upstream requests return redirect
+// responses as they are instead of following them.
+func withoutRedirects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRedirectKey{}, true)
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.Context().Value(noRedirectKey{}) != nil {
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) >= 10 {
+		return errTooManyRedirects
+	}
+
+	return nil
+}
+
+// bucket returns the rate limiter for the given host or nil if the host is
+// not rate limited.
+func (u *upstreamClient) bucket(host string) *tokenBucket {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if bucket, ok := u.buckets[host]; ok {
+		return bucket
+	}
+
+	var bucket *tokenBucket
+
+	if rate, ok := u.RateLimits[host]; ok && rate > 0 {
+		bucket = newTokenBucket(rate, 1)
+	}
+
+	u.buckets[host] = bucket
+
+	return bucket
+}
+
+// send performs a single attempt of the request once a rate limit token and
+// a concurrency slot are available. The token is taken first so that requests
+// waiting on a slow host do not hold slots other hosts could use.
+func (u *upstreamClient) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if bucket := u.bucket(req.URL.Host); bucket != nil {
+		if err := bucket.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case u.slots <- struct{}{}:
+	}
+
+	release := func() { <-u.slots }
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	res.Body = &releasingBody{ReadCloser: res.Body, release: release}
+
+	return res, nil
+}
+
+// backoff returns the delay before the given retry attempt, honoring the
+// Retry-After header of the previous response if there is one.
+func (u *upstreamClient) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if value := res.Header.Get("Retry-After"); len(value) > 0 {
+			if seconds, err := strconv.Atoi(value); err == nil {
+				return u.capDelay(time.Duration(seconds) * time.Second)
+			}
+
+			if date, err := http.ParseTime(value); err == nil {
+				return u.capDelay(time.Until(date))
+			}
+		}
+	}
+
+	delay := u.MinDelay << uint(attempt)
+
+	if delay <= 0 || delay > u.MaxDelay {
+		delay = u.MaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// capDelay bounds a delay requested by the upstream to between zero, e.g. for
+// a Retry-After date in the past, and MaxDelay.
+func (u *upstreamClient) capDelay(delay time.Duration) time.Duration {
+	if delay < 0 {
+		return 0
+	}
+
+	if delay > u.MaxDelay {
+		return u.MaxDelay
+	}
+
+	return delay
+}
+
+// idempotent reports whether req may be sent again after a failure. Like
+// net/http, requests with other methods can opt in by setting an
+// Idempotency-Key or X-Idempotency-Key header.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	_, key := req.Header["Idempotency-Key"]
+	_, xKey := req.Header["X-Idempotency-Key"]
+
+	return key || xKey
+}
+
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || 500 <= code && code <= 599
+}
+
+func (u *upstreamClient) Do(req *http.Request) (*http.Response, error) {
+	if offline {
+		return nil, errOffline
+	}
+
+	client := u.client(req.URL.Host)
+
+	req.Header.Set("User-Agent", u.UserAgent)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+
+			req.Body = body
+		}
+
+		res, err := u.send(client, req)
+
+		if err == nil && !retryable(res.StatusCode) {
+			return res, nil
+		}
+
+		if attempt >= u.Retries || req.Context().Err() != nil || !idempotent(req) || (req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+
+		delay := u.backoff(attempt, res)
+
+		// Give up right away if the retry could not finish in time anyway.
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return res, err
+		}
+
+		if err != nil {
+			log.Printf("Request to %s failed (%s), retrying in %s", req.URL, err, delay)
+		} else {
+			log.Printf("Request to %s returned %s, retrying in %s", req.URL, res.Status, delay)
+			res.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (u *upstreamClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Do(req)
+}
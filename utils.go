@@ -3,13 +3,36 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// fetchConcurrency is the number of chunks fetchList fetches in parallel.
+var fetchConcurrency = getEnvInt("FETCH_CONCURRENCY", 4)
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
 func splitTwo(str string, sep string) (string, string) {
 	fields := strings.Split(str, sep)
 	return fields[0], fields[1]
@@ -29,14 +52,39 @@ func filterEmpty(vs []string) (vsf []string) {
 	return vsf
 }
 
+// validTimestamp reports whether a cache entry is recent enough to be used.
+// Every entry is valid in offline mode since it cannot be refreshed.
 func validTimestamp(timestamp time.Time) bool {
+	if offline {
+		return true
+	}
+
 	duration := time.Since(timestamp)
 
 	return !(duration.Hours() >= 24 /* Hours */ *7 /* Days */ *2 /* Weeks */)
 }
 
-func fetchPart(url string) (string, error) {
-	res, err := http.Get(url)
+// checkStatus converts a non-2xx response status into an error.
+func checkStatus(res *http.Response) error {
+	code := res.StatusCode
+
+	if 200 <= code && code <= 299 {
+		return nil
+	}
+
+	if 400 <= code && code <= 499 {
+		return errHTTPGetClientErr
+	}
+
+	if 500 <= code && code <= 599 {
+		return errHTTPGetServerErr
+	}
+
+	return errHTTPGetUnknownErr
+}
+
+func fetchPart(ctx context.Context, url string) (string, error) {
+	res, err := upstream.Get(ctx, url)
 
 	if err != nil {
 		return "", err
@@ -44,52 +92,118 @@ func fetchPart(url string) (string, error) {
 
 	defer res.Body.Close()
 
-	code := res.StatusCode
-
-	if 200 <= code && code <= 299 {
-		buffer := new(bytes.Buffer)
-		buffer.ReadFrom(res.Body)
-		return buffer.String(), nil
+	if err := checkStatus(res); err != nil {
+		return "", err
 	}
 
-	if 400 <= code && code <= 499 {
-		return "", errHTTPGetClientErr
+	buffer := new(bytes.Buffer)
+	buffer.ReadFrom(res.Body)
+	return buffer.String(), nil
+}
+
+// planChunks splits the list into chunks whose URLs stay below the limit and
+// that hold at most size items, or any number of items if size is zero.
+// Items too long to fit into a URL on their own are returned separately.
+func planChunks(base string, list []string, sep string, limit, size int) (chunks [][]string, oversize []string) {
+	var chunk []string
+
+	length := len(base)
+
+	for _, item := range list {
+		if len(base)+len(item) >= limit {
+			oversize = append(oversize, item)
+			continue
+		}
+
+		if len(chunk) > 0 && (length+len(sep)+len(item) >= limit || len(chunk) == size) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			length = len(base)
+		}
+
+		if len(chunk) > 0 {
+			length += len(sep)
+		}
+
+		chunk = append(chunk, item)
+		length += len(item)
 	}
 
-	if 500 <= code && code <= 599 {
-		return "", errHTTPGetServerErr
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
 	}
 
-	return "", errHTTPGetUnknownErr
+	return chunks, oversize
+}
+
+type chunkResult struct {
+	Index int
+	Body  string
+	Err   error
 }
 
-func fetchList(base string, list []string, sep string, limit int) (string, error) {
-	i := 0
+// fetchList fetches the list in URL length limited chunks with a bounded
+// number of concurrent workers. Each response body is passed to handle as
+// soon as it arrives; handle is never called concurrently. Failed chunks do
+// not stop the others, the first error encountered is returned at the end.
+func fetchList(ctx context.Context, base string, list []string, sep string, limit, size int, handle func(string) error) error {
+	chunks, oversize := planChunks(base, list, sep, limit, size)
 
-	var result string
+	var first error
 
-	log.Printf("Start fetch from: %s\n", base)
+	if len(oversize) > 0 {
+		first = fmt.Errorf("%w: %s", errIDTooLong, strings.Join(oversize, ", "))
+		log.Println(first)
+	}
+
+	log.Printf("Start fetch of %d items in %d chunks from: %s\n", len(list), len(chunks), base)
+
+	workers := fetchConcurrency
+
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+
+	jobs := make(chan int)
+	results := make(chan chunkResult)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				body, err := fetchPart(ctx, base+strings.Join(chunks[i], sep))
+				results <- chunkResult{Index: i, Body: body, Err: err}
+			}
+		}()
+	}
 
-	for i < len(list) {
-		for j := len(list); j > i; j-- {
-			args := strings.Join(list[i:j], sep)
-			if len(base)+len(args) < limit {
-				log.Printf("Get from %d to %d (%d) of %d\n", i+1, j, len(list[i:j]), len(list))
+	go func() {
+		for i := range chunks {
+			jobs <- i
+		}
+		close(jobs)
+	}()
 
-				str, err := fetchPart(base + args)
+	for n := 1; n <= len(chunks); n++ {
+		result := <-results
 
-				if err != nil {
-					return result, err
-				}
+		log.Printf("Got chunk %d (%d items), %d of %d done\n", result.Index+1, len(chunks[result.Index]), n, len(chunks))
 
-				result += str
+		err := result.Err
 
-				i = j
+		if err == nil {
+			err = handle(result.Body)
+		}
+
+		if err != nil {
+			log.Printf("Failed to fetch chunk %d from %s: %s", result.Index+1, base, err)
+
+			if first == nil {
+				first = err
 			}
 		}
 	}
 
-	return result, nil
+	return first
 }
 
 func getDBHost(db string) (string, error) {
@@ -99,6 +213,8 @@ func getDBHost(db string) (string, error) {
 		return "", err
 	}
 
+	defer file.Close()
+
 	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
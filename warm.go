@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// listUniprotAccessions retrieves the accessions of every UniProtKB entry
+// matching the query.
+func listUniprotAccessions(ctx context.Context, query string) ([]string, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("format", "list")
+
+	res, err := upstream.Get(ctx, fmt.Sprintf("%s/uniprotkb/stream?%s", uniprotURL, params.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if err := checkStatus(res); err != nil {
+		return nil, err
+	}
+
+	var ret []string
+
+	scanner := bufio.NewScanner(res.Body)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+			ret = append(ret, line)
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+func readIDs(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var ret []string
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ret = append(ret, strings.Fields(line)[0])
+	}
+
+	return ret, scanner.Err()
+}
+
+// warm fills the cache with the UniProt and LinkDB entries of the given IDs
+// or of a whole proteome. Entries already cached are skipped, so an
+// interrupted run can simply be restarted.
+func warm(args []string) error {
+	flags := flag.NewFlagSet("warm", flag.ExitOnError)
+
+	ids := flags.String("ids", "", "file with one ID per line, in any namespace with a mapping store")
+	taxon := flags.String("taxon", "", "NCBI taxonomy ID of the proteome to warm")
+	reviewed := flags.Bool("reviewed", false, "only warm reviewed (Swiss-Prot) entries of the proteome")
+	batch := flags.Int("batch", 500, "number of UniProt entries fetched per batch")
+
+	flags.Parse(args)
+
+	if len(*ids) == 0 && len(*taxon) == 0 {
+		return fmt.Errorf("warm requires -ids or -taxon")
+	}
+
+	if offline {
+		return errOffline
+	}
+
+	db = openDB()
+	defer db.Close()
+
+	ctx := context.Background()
+
+	var accessions []string
+
+	if len(*ids) > 0 {
+		mappings = createMappings()
+
+		for _, v := range mappings {
+			defer v.Close()
+		}
+
+		queries, err := readIDs(*ids)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Resolving %d IDs", len(queries))
+
+		for _, query := range queries {
+			if converted, err := findMapping(query); err == nil {
+				accessions = append(accessions, converted...)
+			} else {
+				accessions = append(accessions, query)
+			}
+		}
+	}
+
+	if len(*taxon) > 0 {
+		query := "organism_id:" + *taxon
+
+		if *reviewed {
+			query += " AND reviewed:true"
+		}
+
+		log.Printf("Listing UniProt entries for %s", query)
+
+		list, err := listUniprotAccessions(ctx, query)
+
+		if err != nil {
+			return err
+		}
+
+		accessions = append(accessions, list...)
+	}
+
+	var pending []string
+
+	seen := make(map[string]bool)
+
+	for _, accession := range accessions {
+		if seen[accession] {
+			continue
+		}
+
+		seen[accession] = true
+
+		if !warmed(accession) {
+			pending = append(pending, accession)
+		}
+	}
+
+	log.Printf("Warming %d of %d entries, %d already cached", len(pending), len(seen), len(seen)-len(pending))
+
+	failed := 0
+
+	for i := 0; i < len(pending); i += *batch {
+		j := i + *batch
+
+		if j > len(pending) {
+			j = len(pending)
+		}
+
+		n, errs := warmBatch(ctx, pending[i:j])
+
+		failed += errs
+
+		log.Printf("Warmed %d of %d entries (%d in this batch)", j, len(pending), n)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d entries could not be warmed, run warm again to retry them", failed)
+	}
+
+	return nil
+}
+
+// warmBatch fetches the UniProt entries of ids and the LinkDB entries of their
+// KEGG references into the cache. KEGG gene IDs are looked up in LinkDB alone.
+// It returns the number of entries found and the number of IDs that failed to
+// be fetched.
+func warmBatch(ctx context.Context, ids []string) (int, int) {
+	var accessions, genes []string
+
+	for _, id := range ids {
+		if _, ok := parseKeggGeneID(id); ok {
+			genes = append(genes, id)
+		} else {
+			accessions = append(accessions, id)
+		}
+	}
+
+	var list []uniprot
+	var err error
+
+	if len(accessions) > 0 {
+		list, err = getUniprot(ctx, accessions)
+	}
+
+	failed := 0
+
+	if err != nil {
+		log.Printf("Failed to get UniProt entries: %s", err)
+
+		found := foundUniprot(list, accessions)
+
+		for _, accession := range accessions {
+			if !found[accession] {
+				failed++
+			}
+		}
+	}
+
+	keggIDs := append([]string(nil), genes...)
+
+	for _, item := range list {
+		for _, dbReference := range item.DbReference {
+			if dbReference.Type == "KEGG" {
+				keggIDs = append(keggIDs, dbReference.ID)
+			}
+		}
+	}
+
+	if len(keggIDs) == 0 {
+		return len(list), failed
+	}
+
+	links, err := getLinkDB(ctx, keggIDs)
+
+	found := make(map[string]bool)
+
+	for _, item := range links {
+		found[item.ID] = true
+	}
+
+	count := len(list)
+
+	for _, gene := range genes {
+		if found[gene] {
+			count++
+		}
+	}
+
+	if err == nil {
+		return count, failed
+	}
+
+	log.Printf("Failed to get LinkDB entries: %s", err)
+
+	for _, gene := range genes {
+		if !found[gene] {
+			failed++
+		}
+	}
+
+	for _, item := range list {
+		for _, dbReference := range item.DbReference {
+			if dbReference.Type == "KEGG" && !found[dbReference.ID] {
+				failed++
+				break
+			}
+		}
+	}
+
+	return count, failed
+}
+
+// warmed reports whether the UniProt entry of accession and the LinkDB entries
+// of all its KEGG references are cached.
+func warmed(accession string) bool {
+	item, err := uniprotLoadCache(accession)
+
+	if err != nil {
+		return false
+	}
+
+	for _, dbReference := range item.DbReference {
+		if dbReference.Type != "KEGG" {
+			continue
+		}
+
+		if _, err := linkDBLoadCache(dbReference.ID); err != nil {
+			return false
+		}
+	}
+
+	return true
+}